@@ -81,6 +81,25 @@ var (
 			}
 		},
 	}
+
+	discoverFlags = &src.DiscoverFlags{}
+	discoverCmd   = &cobra.Command{
+		Use:   "discover",
+		Short: "Write a repo list of the actions used by local workflows or the repositories of a GHES instance",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := discoverFlags.Validate().Error(); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				_ = cmd.Usage()
+				os.Exit(1)
+				return
+			}
+			if err := src.Discover(cmd.Context(), discoverFlags); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+				return
+			}
+		},
+	}
 )
 
 func Execute(ctx context.Context) error {
@@ -95,5 +114,8 @@ func Execute(ctx context.Context) error {
 	rootCmd.AddCommand(syncRepoCmd)
 	syncRepoFlags.Init(syncRepoCmd)
 
+	rootCmd.AddCommand(discoverCmd)
+	discoverFlags.Init(discoverCmd)
+
 	return rootCmd.ExecuteContext(ctx)
 }
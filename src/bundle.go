@@ -0,0 +1,669 @@
+package src
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bundleSignature starts every git bundle in the version 2 format, which
+	// `git bundle` reads and writes.
+	bundleSignature = "# v2 git bundle"
+	// bundleIndexFile is the first file of a bundle archive, listing the
+	// repository each bundle holds.
+	bundleIndexFile = "index.json"
+	// bundlePackWindow is how many objects are compared when looking for
+	// deltas, as with `git pack-objects`.
+	bundlePackWindow = 10
+)
+
+// bundleHeader is the list of refs a git bundle contains, along with the
+// commits it assumes the receiving repository already has.
+type bundleHeader struct {
+	prerequisites []plumbing.Hash
+	refs          []*plumbing.Reference
+}
+
+// bundleIndex maps the bundles in an archive to the repositories they hold,
+// in the order the bundles are stored.
+type bundleIndex struct {
+	Version int `json:"version"`
+	// SourceEnterpriseURL is carried over from the integrity manifest of the
+	// cache, marking bundles pulled from a GHES source
+	SourceEnterpriseURL string              `json:"source-enterprise-url,omitempty"`
+	Repositories        []*bundleIndexEntry `json:"repositories"`
+}
+
+// bundleIndexEntry describes the bundle of one repository.
+type bundleIndexEntry struct {
+	Repo   string `json:"repo"`
+	Bundle string `json:"bundle"`
+	// Head is the branch HEAD of the cached repository pointed at
+	Head string `json:"head,omitempty"`
+	// Visibility is the source visibility recorded by pull, if any
+	Visibility string `json:"visibility,omitempty"`
+	// Incremental bundles only hold what changed since a push receipt, and
+	// are applied on top of the repository on the destination
+	Incremental bool `json:"incremental,omitempty"`
+	// Deleted lists the branches and tags in the receipt that are gone from
+	// the cache
+	Deleted []string `json:"deleted,omitempty"`
+	SHA256  string   `json:"sha256"`
+}
+
+// writeBundle writes a bundle of refs with the objects they reach. Objects
+// reachable from base are left out, and the commits of base become the
+// prerequisites of the bundle.
+func writeBundle(w io.Writer, repo *git.Repository, refs []*plumbing.Reference, base []plumbing.Hash) error {
+	var header strings.Builder
+	header.WriteString(bundleSignature + "\n")
+	for _, hash := range bundlePrerequisites(repo, base) {
+		fmt.Fprintf(&header, "-%s\n", hash)
+	}
+	tips := make([]plumbing.Hash, 0, len(refs))
+	for _, ref := range refs {
+		fmt.Fprintf(&header, "%s %s\n", ref.Hash(), ref.Name())
+		tips = append(tips, ref.Hash())
+	}
+	header.WriteString("\n")
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+
+	var hashes []plumbing.Hash
+	if len(tips) > 0 {
+		var err error
+		hashes, err = revlist.Objects(repo.Storer, tips, base)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := packfile.NewEncoder(w, repo.Storer, false).Encode(hashes, bundlePackWindow)
+	return err
+}
+
+// readBundleHeader reads the header of a bundle, leaving r at the start of its
+// packfile.
+func readBundleHeader(r *bufio.Reader) (*bundleHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSuffix(line, "\n") != bundleSignature {
+		return nil, errors.New("not a v2 git bundle")
+	}
+	header := &bundleHeader{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading bundle header")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+		if prerequisite, ok := strings.CutPrefix(line, "-"); ok {
+			hash, _, _ := strings.Cut(prerequisite, " ")
+			if !plumbing.IsHash(hash) {
+				return nil, errors.Errorf("invalid bundle prerequisite `%s`", line)
+			}
+			header.prerequisites = append(header.prerequisites, plumbing.NewHash(hash))
+			continue
+		}
+		hash, name, found := strings.Cut(line, " ")
+		if !found || !plumbing.IsHash(hash) {
+			return nil, errors.Errorf("invalid bundle ref `%s`", line)
+		}
+		header.refs = append(header.refs, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+	}
+}
+
+// bundleRefs returns the branches and tags of a cached repository, sorted by
+// name, and the branch its HEAD points at.
+func bundleRefs(repo *git.Repository) ([]*plumbing.Reference, string, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, "", err
+	}
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag()) {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name() < refs[j].Name() })
+
+	var head string
+	if ref, err := repo.Reference(plumbing.HEAD, false); err == nil && ref.Type() == plumbing.SymbolicReference {
+		head = ref.Target().String()
+	}
+	return refs, head, nil
+}
+
+// bundlePrerequisites returns the commits that base points at, following
+// annotated tags to their commit.
+func bundlePrerequisites(repo *git.Repository, base []plumbing.Hash) []plumbing.Hash {
+	var prerequisites []plumbing.Hash
+	seen := map[plumbing.Hash]bool{}
+	for _, hash := range base {
+		obj, err := object.GetObject(repo.Storer, hash)
+		for err == nil {
+			tag, ok := obj.(*object.Tag)
+			if !ok {
+				break
+			}
+			obj, err = tag.Object()
+		}
+		if commit, ok := obj.(*object.Commit); ok && err == nil && !seen[commit.Hash] {
+			seen[commit.Hash] = true
+			prerequisites = append(prerequisites, commit.Hash)
+		}
+	}
+	return prerequisites
+}
+
+// changedRefs returns the refs the receipt doesn't list at the same hash, and
+// the branches and tags of the receipt that are no longer among refs.
+func changedRefs(refs []*plumbing.Reference, since *receiptRepo) ([]*plumbing.Reference, []string) {
+	var changed []*plumbing.Reference
+	current := map[string]bool{}
+	for _, ref := range refs {
+		current[ref.Name().String()] = true
+		if since.Refs[ref.Name().String()] != ref.Hash().String() {
+			changed = append(changed, ref)
+		}
+	}
+	var deleted []string
+	for _, name := range sortedKeys(since.Refs) {
+		if !current[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	return changed, deleted
+}
+
+// receiptBase returns the hashes in the receipt that the repository has. The
+// destination has them along with everything they reach.
+func receiptBase(repo *git.Repository, since *receiptRepo) []plumbing.Hash {
+	var base []plumbing.Hash
+	seen := map[plumbing.Hash]bool{}
+	for _, name := range sortedKeys(since.Refs) {
+		hash := plumbing.NewHash(since.Refs[name])
+		if seen[hash] || repo.Storer.HasEncodedObject(hash) != nil {
+			continue
+		}
+		seen[hash] = true
+		base = append(base, hash)
+	}
+	return base
+}
+
+// exportBundleArchive writes every repository in the cache to a tar archive
+// holding the index followed by one bundle per repository. The bundles are
+// written to a directory next to the archive first, as their checksums go
+// into the index at the front. Given a push receipt, repositories the receipt
+// lists only get what changed since, and are left out when nothing did.
+func exportBundleArchive(cacheDir, file string, since *pushReceipt, out io.Writer) error {
+	repoNames, err := getRepoNamesFromCacheDir(&CommonFlags{CacheDir: cacheDir})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(file), ".actions-sync-bundles-")
+	if err != nil {
+		return errors.Wrap(err, "error creating a directory for the bundles")
+	}
+	defer os.RemoveAll(tmp)
+
+	index := &bundleIndex{Version: 1}
+	m, err := readIntegrityManifest(cacheDir)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		index.SourceEnterpriseURL = m.SourceEnterpriseURL
+	}
+	for _, nwo := range repoNames {
+		repoSince := since.repo(nwo)
+		entry, err := writeRepoBundle(path.Join(cacheDir, nwo), path.Join(tmp, nwo+".bundle"), repoSince)
+		if err != nil {
+			return errors.Wrapf(err, "error bundling `%s`", nwo)
+		}
+		if entry == nil && repoSince != nil {
+			fmt.Fprintf(out, "`%s` hasn't changed since the push receipt, leaving it out of the archive\n", nwo)
+			continue
+		}
+		if entry == nil {
+			fmt.Fprintf(out, "WARNING: `%s` has no branches or tags, leaving it out of the archive\n", nwo)
+			continue
+		}
+		entry.Repo = nwo
+		entry.Bundle = "bundles/" + nwo + ".bundle"
+		index.Repositories = append(index.Repositories, entry)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return errors.Wrapf(err, "error creating `%s`", file)
+	}
+	indexDigest, err := writeBundleArchive(f, index, tmp)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error writing `%s`", file)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "error writing `%s`", file)
+	}
+	fmt.Fprintf(out, "exported %d repositories to %s\n", len(index.Repositories), file)
+	fmt.Fprintf(out, "the bundle index has digest %s, pass it to push --from-bundle with --integrity-digest to verify the archive\n", indexDigest)
+	return nil
+}
+
+// writeRepoBundle writes a bundle of the branches and tags of the repository
+// cached at dir to file. With since, the bundle only holds the refs that
+// changed since the receipt and the objects the destination doesn't have. It
+// returns nil when there is nothing to bundle.
+func writeRepoBundle(dir, file string, since *receiptRepo) (*bundleIndexEntry, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, head, err := bundleRefs(repo)
+	if err != nil || (len(refs) == 0 && since == nil) {
+		return nil, err
+	}
+	entry := &bundleIndexEntry{Head: head}
+	var base []plumbing.Hash
+	if since != nil {
+		refs, entry.Deleted = changedRefs(refs, since)
+		if len(refs) == 0 && len(entry.Deleted) == 0 {
+			return nil, nil
+		}
+		base = receiptBase(repo, since)
+		entry.Incremental = len(base) > 0
+	}
+	// a HEAD line lets git clone the bundle with its default branch checked out
+	for _, ref := range refs {
+		if head != "" && ref.Name().String() == head {
+			refs = append(refs, plumbing.NewHashReference(plumbing.HEAD, ref.Hash()))
+			break
+		}
+	}
+	if cached, err := (gitImplementation{}).NewGitRepository(dir); err == nil {
+		entry.Visibility = cachedVisibility(cached)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if err := writeBundle(io.MultiWriter(f, h), repo, refs, base); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return entry, nil
+}
+
+// writeBundleArchive writes the index and then the bundles in dir to w as a
+// tar archive, and returns the digest of the index.
+func writeBundleArchive(w io.Writer, index *bundleIndex, dir string) (string, error) {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleIndexFile, Mode: 0o644, Size: int64(len(data))}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return "", err
+	}
+	for _, entry := range index.Repositories {
+		if err := addTarFile(tw, entry.Bundle, path.Join(dir, entry.Repo+".bundle")); err != nil {
+			return "", err
+		}
+	}
+	return digest(data), tw.Close()
+}
+
+// addTarFile copies file into the archive under name.
+func addTarFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// bundleArchive reads an archive written by exportBundleArchive from front to
+// back, so bundles are only available in the order of the index.
+type bundleArchive struct {
+	file    string
+	f       *os.File
+	tar     *tar.Reader
+	index   *bundleIndex
+	entries map[string]*bundleIndexEntry
+	// digest of the index, which vouches for the checksums of the bundles
+	digest string
+}
+
+// openBundleArchive opens the archive in file and reads its index.
+func openBundleArchive(file string) (*bundleArchive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening bundle archive `%s`", file)
+	}
+	a := &bundleArchive{file: file, f: f, tar: tar.NewReader(f), index: &bundleIndex{}, entries: map[string]*bundleIndexEntry{}}
+	hdr, err := a.tar.Next()
+	if err != nil || hdr.Name != bundleIndexFile {
+		f.Close()
+		return nil, errors.Errorf("`%s` is not a bundle archive, it doesn't start with %s", file, bundleIndexFile)
+	}
+	data, err := io.ReadAll(a.tar)
+	if err == nil {
+		err = json.Unmarshal(data, a.index)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "error reading the index of `%s`", file)
+	}
+	a.digest = digest(data)
+	for _, entry := range a.index.Repositories {
+		a.entries[entry.Repo] = entry
+	}
+	return a, nil
+}
+
+func (a *bundleArchive) Close() error {
+	return a.f.Close()
+}
+
+// repoNames returns the repositories to push from the archive, in the order
+// they are stored: those named by the repo flags, or else every repository.
+// Wildcard entries are matched against the repositories in the archive.
+func (a *bundleArchive) repoNames(flags *CommonFlags) ([]string, error) {
+	archived := make([]string, 0, len(a.index.Repositories))
+	for _, entry := range a.index.Repositories {
+		archived = append(archived, entry.Repo)
+	}
+	repoNames, err := getRepoNamesFromRepoFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	if repoNames == nil {
+		return archived, nil
+	}
+	if hasRepoPatterns(repoNames) {
+		repoNames, err = matchCachedRepos(repoNames, archived)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	byRepo := map[string]string{}
+	for _, repoName := range repoNames {
+		spec, err := parseRepoSpec(repoName)
+		if err != nil {
+			return nil, err
+		}
+		if a.entries[spec.dest] == nil {
+			return nil, errors.Errorf("`%s` is not in the bundle archive `%s`", spec.dest, a.file)
+		}
+		byRepo[spec.dest] = repoName
+	}
+	ordered := []string{}
+	for _, nwo := range archived {
+		if repoName, ok := byRepo[nwo]; ok {
+			ordered = append(ordered, repoName)
+		}
+	}
+	return ordered, nil
+}
+
+// next skips ahead to the bundle of the repository nwo and returns its index
+// entry and contents.
+func (a *bundleArchive) next(nwo string) (*bundleIndexEntry, io.Reader, error) {
+	entry := a.entries[nwo]
+	if entry == nil {
+		return nil, nil, errors.Errorf("`%s` is not in the bundle archive `%s`", nwo, a.file)
+	}
+	for {
+		hdr, err := a.tar.Next()
+		if err == io.EOF {
+			return nil, nil, errors.Errorf("the bundle of `%s` is missing from `%s`", nwo, a.file)
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error reading `%s`", a.file)
+		}
+		if hdr.Name == entry.Bundle {
+			return entry, a.tar, nil
+		}
+	}
+}
+
+// importBundle creates a bare repository at dir from the bundle read from r,
+// checking it against the checksum in the index. The bundle is copied next to
+// dir and checked before anything of it is imported, and a mismatch is an
+// error unless ignoreMismatch is set. Incremental bundles are applied to the
+// repository fetched from the destination into dir instead, which must have
+// the commits they build on.
+func importBundle(dir string, entry *bundleIndexEntry, r io.Reader, ignoreMismatch bool, out io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	spool, err := os.CreateTemp(filepath.Dir(dir), ".bundle-")
+	if err != nil {
+		return errors.Wrap(err, "error creating a file to read the bundle into")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, h), r); err != nil {
+		return errors.Wrapf(err, "error reading the bundle of `%s`", entry.Repo)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != entry.SHA256 {
+		if !ignoreMismatch {
+			return errors.Errorf("the bundle of `%s` does not match the checksum in the index, refusing to push. Pass --ignore-integrity-mismatch to push anyway", entry.Repo)
+		}
+		fmt.Fprintf(out, "WARNING: the bundle of `%s` does not match the checksum in the index, pushing anyway because --ignore-integrity-mismatch is set\n", entry.Repo)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(spool)
+	header, err := readBundleHeader(br)
+	if err != nil {
+		return err
+	}
+
+	var repo *git.Repository
+	if entry.Incremental {
+		repo, err = git.PlainOpen(dir)
+		if err != nil {
+			return err
+		}
+		for _, hash := range header.prerequisites {
+			if repo.Storer.HasEncodedObject(hash) != nil {
+				return errors.Errorf("the destination doesn't have commit %s that the incremental bundle of `%s` builds on. Export the repository again without --since-receipt", hash, entry.Repo)
+			}
+		}
+	} else {
+		if len(header.prerequisites) > 0 {
+			return errors.Errorf("the bundle of `%s` has prerequisites, but the index doesn't mark it incremental", entry.Repo)
+		}
+		repo, err = git.PlainInit(dir, true)
+		if err != nil {
+			return err
+		}
+	}
+	if err := packfile.UpdateObjectStorage(repo.Storer, br); err != nil {
+		return errors.Wrapf(err, "error reading the bundle of `%s`", entry.Repo)
+	}
+
+	for _, ref := range header.refs {
+		if ref.Name() == plumbing.HEAD {
+			continue
+		}
+		if err := repo.Storer.SetReference(ref); err != nil {
+			return err
+		}
+	}
+	for _, name := range entry.Deleted {
+		if err := repo.Storer.RemoveReference(plumbing.ReferenceName(name)); err != nil {
+			return err
+		}
+	}
+	if entry.Head != "" {
+		if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName(entry.Head))); err != nil {
+			return err
+		}
+	}
+	if entry.Visibility != "" {
+		cached, err := (gitImplementation{}).NewGitRepository(dir)
+		if err != nil {
+			return err
+		}
+		return setCachedVisibility(cached, entry.Visibility)
+	}
+	return nil
+}
+
+// pushBundleArchive pushes repoNames from the archive one at a time. Each
+// bundle is imported into a temporary repository under the cache directory,
+// pushed and deleted before the next one is read.
+func pushBundleArchive(ctx context.Context, flags *PushFlags, archive *bundleArchive, repoNames []string, ghClient *github.Client, gitimpl GitImplementation) error {
+	if err := os.MkdirAll(flags.CacheDir, 0o755); err != nil {
+		return errors.Wrapf(err, "error creating cache directory `%s`", flags.CacheDir)
+	}
+	tmp, err := os.MkdirTemp(flags.CacheDir, ".bundle-import-")
+	if err != nil {
+		return errors.Wrap(err, "error creating a directory to import bundles into")
+	}
+	defer os.RemoveAll(tmp)
+	importFlags := *flags
+	importFlags.CacheDir = tmp
+
+	// the archive is read front to back, so bundles are pushed in order
+	return forEachRepo(ctx, repoNames, 1, flags.ContinueOnError, func(ctx context.Context, repoName string, out io.Writer) error {
+		spec, err := parseRepoSpec(repoName)
+		if err != nil {
+			return err
+		}
+		entry, r, err := archive.next(spec.dest)
+		if err != nil {
+			return err
+		}
+		repoFlags, repoClient, err := importFlags.forRepo(repoName, ghClient)
+		if err != nil {
+			return err
+		}
+		dir, err := importDir(tmp, spec.dest)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if entry.Incremental {
+			if err := fetchDestination(ctx, repoFlags, repoClient, spec.dest, dir, out); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(out, "importing the bundle of `%s` ...\n", spec.dest)
+		if err := importBundle(dir, entry, r, flags.IgnoreIntegrityMismatch, out); err != nil {
+			return err
+		}
+		return PushWithGitImpl(ctx, repoFlags, repoName, out, repoClient, gitimpl)
+	})
+}
+
+// importDir returns the directory under tmp to import the bundle of nwo into.
+// The name comes from the index of the archive, so one that would point
+// outside tmp is refused before anything is written or removed.
+func importDir(tmp, nwo string) (string, error) {
+	dir := filepath.Join(tmp, filepath.FromSlash(nwo))
+	rel, err := filepath.Rel(tmp, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("`%s` in the bundle index is not a valid repo name", nwo)
+	}
+	return dir, nil
+}
+
+// fetchDestination fetches the branches and tags of nwo from the destination
+// into a new bare repository at dir, for an incremental bundle to be applied
+// to.
+func fetchDestination(ctx context.Context, flags *PushFlags, ghClient *github.Client, nwo, dir string, out io.Writer) error {
+	ownerName, repoName, err := splitNwo(destinationNwo(nwo))
+	if err != nil {
+		return err
+	}
+	ghRepo, _, err := ghClient.Repositories.Get(ctx, ownerName, repoName)
+	if err != nil {
+		return errors.Wrapf(err, "error getting `%s` from the destination, which its incremental bundle builds on", nwo)
+	}
+	cloneURL, dest := flags.gitDestination(ghRepo)
+	ctx = dest.gitContext(ctx)
+
+	repo, err := git.PlainInit(dir, true)
+	if err != nil {
+		return err
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{Name: "ghes", URLs: []string{cloneURL}})
+	if err != nil {
+		return errors.Wrap(err, "error creating remote")
+	}
+	fmt.Fprintf(out, "fetching `%s` from the destination to apply its incremental bundle to ...\n", nwo)
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{
+			"+refs/heads/*:refs/heads/*",
+			"+refs/tags/*:refs/tags/*",
+		},
+		Auth:            dest.auth,
+		CABundle:        dest.caBundle,
+		InsecureSkipTLS: dest.insecure,
+		ProxyOptions:    dest.proxy,
+		Tags:            git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate && err != transport.ErrEmptyRemoteRepository {
+		return errors.Wrapf(withTokenError(dest.auth, err), "error fetching `%s` from the destination", nwo)
+	}
+	return nil
+}
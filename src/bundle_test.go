@@ -0,0 +1,233 @@
+package src
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initTestBundleCache creates a cache with two repositories, one of them with
+// an annotated tag and a recorded visibility.
+func initTestBundleCache(t *testing.T) (string, plumbing.Hash) {
+	t.Helper()
+	cacheDir := t.TempDir()
+	dir := path.Join(cacheDir, "actions", "checkout")
+	hash := initTestRepository(t, dir)
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v2.0.0", hash, &git.CreateTagOptions{
+		Message: "v2.0.0",
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	cached, err := gitImplementation{}.NewGitRepository(dir)
+	require.NoError(t, err)
+	require.NoError(t, setCachedVisibility(cached, "internal"))
+
+	initTestRepository(t, path.Join(cacheDir, "actions", "setup-go"))
+	return cacheDir, hash
+}
+
+func TestExportBundleArchive_RoundTrip(t *testing.T) {
+	cacheDir, hash := initTestBundleCache(t)
+	file := path.Join(t.TempDir(), "cache.tar")
+	var out bytes.Buffer
+	require.NoError(t, exportBundleArchive(cacheDir, file, nil, &out))
+	assert.Contains(t, out.String(), "exported 2 repositories")
+
+	archive, err := openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+	require.Len(t, archive.index.Repositories, 2)
+	assert.Contains(t, out.String(), "the bundle index has digest "+archive.digest)
+	entry := archive.index.Repositories[0]
+	assert.Equal(t, "actions/checkout", entry.Repo)
+	assert.Equal(t, "bundles/actions/checkout.bundle", entry.Bundle)
+	assert.Equal(t, "refs/heads/main", entry.Head)
+	assert.Equal(t, "internal", entry.Visibility)
+
+	// the first bundle is skipped to get to the second
+	entry, r, err := archive.next("actions/setup-go")
+	require.NoError(t, err)
+	dir := path.Join(t.TempDir(), "actions", "setup-go")
+	require.NoError(t, importBundle(dir, entry, r, false, &out))
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	ref, err := repo.Reference(plumbing.NewTagReferenceName("v1.0.0"), true)
+	require.NoError(t, err)
+	_, err = repo.CommitObject(ref.Hash())
+	require.NoError(t, err)
+
+	_, _, err = archive.next("actions/checkout")
+	assert.Error(t, err, "bundles can only be read in order")
+
+	archive, err = openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+	entry, r, err = archive.next("actions/checkout")
+	require.NoError(t, err)
+	dir = path.Join(t.TempDir(), "actions", "checkout")
+	require.NoError(t, importBundle(dir, entry, r, false, &out))
+	repo, err = git.PlainOpen(dir)
+	require.NoError(t, err)
+	head, err := repo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("main"), head.Name())
+	assert.Equal(t, hash, head.Hash())
+	tagRef, err := repo.Reference(plumbing.NewTagReferenceName("v2.0.0"), true)
+	require.NoError(t, err)
+	tag, err := repo.TagObject(tagRef.Hash())
+	require.NoError(t, err, "annotated tags keep their tag object")
+	assert.Equal(t, hash, tag.Target)
+	cached, err := gitImplementation{}.NewGitRepository(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "internal", cachedVisibility(cached))
+}
+
+func TestImportBundle_ChecksumMismatch(t *testing.T) {
+	cacheDir, _ := initTestBundleCache(t)
+	file := path.Join(t.TempDir(), "cache.tar")
+	require.NoError(t, exportBundleArchive(cacheDir, file, nil, io.Discard))
+
+	archive, err := openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+	entry, r, err := archive.next("actions/checkout")
+	require.NoError(t, err)
+	entry.SHA256 = strings.Repeat("0", 64)
+	parent := t.TempDir()
+	err = importBundle(path.Join(parent, "checkout"), entry, r, false, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to push")
+	files, err := os.ReadDir(parent)
+	require.NoError(t, err)
+	assert.Empty(t, files, "nothing is imported from a bundle that doesn't match")
+
+	entry, r, err = archive.next("actions/setup-go")
+	require.NoError(t, err)
+	entry.SHA256 = strings.Repeat("0", 64)
+	var out bytes.Buffer
+	require.NoError(t, importBundle(path.Join(t.TempDir(), "setup-go"), entry, r, true, &out))
+	assert.Contains(t, out.String(), "pushing anyway")
+}
+
+func TestBundleArchive_RepoNames(t *testing.T) {
+	cacheDir, _ := initTestBundleCache(t)
+	file := path.Join(t.TempDir(), "cache.tar")
+	require.NoError(t, exportBundleArchive(cacheDir, file, nil, io.Discard))
+	archive, err := openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	repoNames, err := archive.repoNames(&CommonFlags{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout", "actions/setup-go"}, repoNames)
+
+	repoNames, err = archive.repoNames(&CommonFlags{RepoNameList: "actions/setup-go,actions/checkout@v1.0.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout@v1.0.0", "actions/setup-go"}, repoNames, "repositories are pushed in the order of the archive")
+
+	repoNames, err = archive.repoNames(&CommonFlags{RepoName: "actions/setup-*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/setup-go"}, repoNames)
+
+	_, err = archive.repoNames(&CommonFlags{RepoName: "actions/cache"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in the bundle archive")
+}
+
+func TestOpenBundleArchive_NotAnArchive(t *testing.T) {
+	file := writeTestManifest(t, "cache.tar", "not a tar file")
+	_, err := openBundleArchive(file)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a bundle archive")
+}
+
+func TestReadBundleHeader(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	header, err := readBundleHeader(bufio.NewReader(strings.NewReader(bundleSignature + "\n-" + sha + " old commit\n" + sha + " refs/heads/main\n\nPACK")))
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{plumbing.NewHash(sha)}, header.prerequisites)
+	require.Len(t, header.refs, 1)
+	assert.Equal(t, plumbing.NewBranchReferenceName("main"), header.refs[0].Name())
+
+	_, err = readBundleHeader(bufio.NewReader(strings.NewReader("# v3 git bundle\n\n")))
+	assert.Error(t, err)
+	_, err = readBundleHeader(bufio.NewReader(strings.NewReader(bundleSignature + "\nnot-a-sha refs/heads/main\n\n")))
+	assert.Error(t, err)
+}
+
+// writeTestBundleArchive writes an archive whose index lists entry alone,
+// with content as its bundle.
+func writeTestBundleArchive(t *testing.T, entry *bundleIndexEntry, content []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(content)
+	entry.Bundle = "bundles/repo.bundle"
+	entry.SHA256 = hex.EncodeToString(sum[:])
+	index := &bundleIndex{Version: 1, Repositories: []*bundleIndexEntry{entry}}
+	data, err := json.Marshal(index)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: bundleIndexFile, Mode: 0o644, Size: int64(len(data))}))
+	_, err = tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bundles/repo.bundle", Mode: 0o644, Size: int64(len(content))}))
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	file := path.Join(t.TempDir(), "cache.tar")
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0o644))
+	return file
+}
+
+func TestPushBundleArchive_MaliciousIndex(t *testing.T) {
+	for _, repo := range []string{"../escape", "owner/..", "owner/../../escape"} {
+		parent := t.TempDir()
+		cacheDir := path.Join(parent, "cache")
+		archive, err := openBundleArchive(writeTestBundleArchive(t, &bundleIndexEntry{Repo: repo}, []byte("bundle")))
+		require.NoError(t, err)
+		repoNames, err := archive.repoNames(&CommonFlags{})
+		require.NoError(t, err)
+
+		flags := &PushFlags{CommonFlags: CommonFlags{CacheDir: cacheDir}}
+		err = pushBundleArchive(context.Background(), flags, archive, repoNames, nil, gitImplementation{})
+		archive.Close()
+		require.Error(t, err, repo)
+		assert.Contains(t, err.Error(), "not a valid repo name", repo)
+
+		files, err := os.ReadDir(parent)
+		require.NoError(t, err)
+		require.Len(t, files, 1, "nothing is written next to the cache (%s)", repo)
+		files, err = os.ReadDir(cacheDir)
+		require.NoError(t, err)
+		assert.Empty(t, files, "nothing is left in the cache (%s)", repo)
+	}
+
+	tmp := t.TempDir()
+	for _, nwo := range []string{"owner/..", "../escape", "owner/../../escape"} {
+		_, err := importDir(tmp, nwo)
+		require.Error(t, err, nwo)
+	}
+	dir, err := importDir(tmp, "group/sub/action")
+	require.NoError(t, err)
+	assert.Equal(t, path.Join(tmp, "group", "sub", "action"), dir)
+}
@@ -0,0 +1,180 @@
+package src
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CacheLayoutBare stores each cached repository as a bare git repository,
+	// without a checked out working tree.
+	CacheLayoutBare = "bare"
+	// CacheLayoutWorktree stores each cached repository as a regular clone with
+	// the default branch checked out (the original layout).
+	CacheLayoutWorktree = "worktree"
+)
+
+// isCachedRepository reports whether dir holds a cached repository in either
+// layout, rather than being a namespace directory of more repositories.
+func isCachedRepository(dir string) bool {
+	for _, name := range []string{git.GitDirName, "HEAD"} {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// The conversion of a cached repository to bare builds the bare repository in
+// a sibling directory with bareBuildSuffix and then swaps it in, moving the
+// old repository aside to a directory with bareReplacedSuffix until it is
+// deleted. Either is only left behind by an interrupted conversion.
+const (
+	bareBuildSuffix    = ".bare-tmp"
+	bareReplacedSuffix = ".bare-old"
+)
+
+// isBareConversionLeftover reports whether name is a directory left behind by
+// an interrupted conversion rather than a cached repository.
+func isBareConversionLeftover(name string) bool {
+	return strings.HasSuffix(name, bareBuildSuffix) || strings.HasSuffix(name, bareReplacedSuffix)
+}
+
+// convertToBare turns a cached repository with a working tree into a bare
+// repository. The bare repository is built next to dir from a copy of `.git`
+// and only swapped in once complete, so an interruption leaves either the old
+// repository or the new one in place, which recoverBareConversion sorts out.
+// It reports whether the repository was converted; repositories that are
+// already bare are left untouched.
+func convertToBare(dir string) (bool, error) {
+	if err := recoverBareConversion(dir); err != nil {
+		return false, err
+	}
+	gitDir := path.Join(dir, git.GitDirName)
+	info, err := os.Stat(gitDir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error inspecting cached repository `%s`", dir)
+	}
+	if !info.IsDir() {
+		return false, errors.Errorf("`%s` is a linked worktree and cannot be converted to a bare repository", dir)
+	}
+
+	build := dir + bareBuildSuffix
+	if err := buildBareRepository(gitDir, build); err != nil {
+		_ = os.RemoveAll(build)
+		return false, err
+	}
+
+	replaced := dir + bareReplacedSuffix
+	if err := os.Rename(dir, replaced); err != nil {
+		_ = os.RemoveAll(build)
+		return false, errors.Wrapf(err, "error moving `%s` aside", dir)
+	}
+	if err := os.Rename(build, dir); err != nil {
+		return false, errors.Wrapf(err, "error moving the bare repository into `%s`", dir)
+	}
+	if err := os.RemoveAll(replaced); err != nil {
+		return false, errors.Wrapf(err, "error removing working tree of `%s`", dir)
+	}
+	return true, nil
+}
+
+// buildBareRepository creates a bare repository at build from the git
+// directory gitDir, which is left as it is. Objects never change once
+// written, so they are hard linked where possible rather than copied.
+func buildBareRepository(gitDir, build string) error {
+	err := filepath.WalkDir(gitDir, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(gitDir, src)
+		if err != nil {
+			return err
+		}
+		// The index only describes the working tree that is being removed.
+		if rel == "index" {
+			return nil
+		}
+		dst := filepath.Join(build, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(dst, 0o755)
+		}
+		if strings.HasPrefix(filepath.ToSlash(rel), "objects/") && os.Link(src, dst) == nil {
+			return nil
+		}
+		return copyFile(src, dst)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error copying git directory `%s`", gitDir)
+	}
+
+	repo, err := git.PlainOpen(build)
+	if err != nil {
+		return errors.Wrapf(err, "error opening converted repository `%s`", build)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return errors.Wrapf(err, "error reading config of `%s`", build)
+	}
+	cfg.Core.IsBare = true
+	cfg.Core.Worktree = ""
+	if err := repo.SetConfig(cfg); err != nil {
+		return errors.Wrapf(err, "error updating config of `%s`", build)
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst, keeping its permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// recoverBareConversion cleans up after an interrupted conversion of dir. If
+// dir was moved aside but the complete bare repository not yet moved in, the
+// bare repository is moved in now; a bare repository that was still being
+// built is discarded, as dir was left intact.
+func recoverBareConversion(dir string) error {
+	build, replaced := dir+bareBuildSuffix, dir+bareReplacedSuffix
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		restore := replaced
+		if _, err := os.Stat(build); err == nil {
+			restore = build
+		}
+		if err := os.Rename(restore, dir); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error restoring `%s` after an interrupted conversion", dir)
+		}
+	}
+	for _, leftover := range []string{build, replaced} {
+		if err := os.RemoveAll(leftover); err != nil {
+			return errors.Wrapf(err, "error removing `%s` left by an interrupted conversion", leftover)
+		}
+	}
+	return nil
+}
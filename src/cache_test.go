@@ -0,0 +1,182 @@
+package src
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initTestRepository creates a repository with a working tree at dir holding a
+// single commit on main tagged v1.0.0.
+func initTestRepository(t *testing.T, dir string) plumbing.Hash {
+	t.Helper()
+
+	repo, err := git.PlainInitWithOptions(dir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	hash := commitTestFile(t, dir, "name: test\n", time.Now())
+	_, err = repo.CreateTag("v1.0.0", hash, nil)
+	require.NoError(t, err)
+	return hash
+}
+
+// commitTestFile commits action.yml with the given content to the repository
+// at dir, dated when.
+func commitTestFile(t *testing.T, dir, content string, when time.Time) plumbing.Hash {
+	t.Helper()
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path.Join(dir, "action.yml"), []byte(content), 0o644))
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("action.yml")
+	require.NoError(t, err)
+	hash, err := wt.Commit("update action.yml", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: when},
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestConvertToBare(t *testing.T) {
+	dir := path.Join(t.TempDir(), "actions", "checkout")
+	hash := initTestRepository(t, dir)
+
+	converted, err := convertToBare(dir)
+	require.NoError(t, err)
+	assert.True(t, converted)
+
+	assert.NoDirExists(t, path.Join(dir, ".git"))
+	assert.NoFileExists(t, path.Join(dir, "action.yml"), "the working tree should be removed")
+	assert.NoFileExists(t, path.Join(dir, "index"))
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	assert.True(t, cfg.Core.IsBare)
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+	ref, err = repo.Reference(plumbing.NewTagReferenceName("v1.0.0"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+}
+
+func TestConvertToBare_AlreadyBare(t *testing.T) {
+	dir := path.Join(t.TempDir(), "actions", "checkout")
+	initTestRepository(t, dir)
+	_, err := convertToBare(dir)
+	require.NoError(t, err)
+
+	converted, err := convertToBare(dir)
+	require.NoError(t, err)
+	assert.False(t, converted, "a bare repository should be left untouched")
+
+	_, err = git.PlainOpen(dir)
+	require.NoError(t, err)
+}
+
+func TestConvertToBare_FailureKeepsRepository(t *testing.T) {
+	dir := path.Join(t.TempDir(), "actions", "checkout")
+	hash := initTestRepository(t, dir)
+	// a file that can't be copied makes the conversion fail partway through
+	require.NoError(t, os.Symlink("missing", path.Join(dir, ".git", "hooks-broken")))
+
+	_, err := convertToBare(dir)
+	require.Error(t, err)
+
+	assert.FileExists(t, path.Join(dir, "action.yml"), "the working tree is kept")
+	assert.NoDirExists(t, dir+bareBuildSuffix, "the partial bare repository is removed")
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+}
+
+func TestRecoverBareConversion(t *testing.T) {
+	root := path.Join(t.TempDir(), "actions")
+	dir := path.Join(root, "checkout")
+
+	// interrupted while building: the repository is intact and the build discarded
+	initTestRepository(t, dir)
+	require.NoError(t, os.MkdirAll(path.Join(dir+bareBuildSuffix, "objects"), 0o755))
+	require.NoError(t, recoverBareConversion(dir))
+	assert.NoDirExists(t, dir+bareBuildSuffix)
+	assert.DirExists(t, path.Join(dir, ".git"))
+
+	// interrupted between moving the repository aside and the bare one in
+	hash := initTestRepository(t, dir+bareBuildSuffix)
+	_, err := convertToBare(dir + bareBuildSuffix)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(dir, dir+bareReplacedSuffix))
+	repoNames, err := getRepoNamesFromNamespaceDir(root, "actions")
+	require.NoError(t, err)
+	assert.Empty(t, repoNames, "leftovers aren't taken for cached repositories")
+
+	require.NoError(t, recoverBareConversion(dir))
+	assert.NoDirExists(t, dir+bareBuildSuffix)
+	assert.NoDirExists(t, dir+bareReplacedSuffix)
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	assert.True(t, cfg.Core.IsBare, "the complete bare repository is moved in")
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+}
+
+func TestPullWithGitImpl_BareCacheCanBePushed(t *testing.T) {
+	srcDir := t.TempDir()
+	hash := initTestRepository(t, path.Join(srcDir, "actions", "checkout"))
+	cacheDir := t.TempDir()
+
+	flags := newTestPullFlags(cacheDir, false)
+	flags.SourceURL = "file://" + srcDir
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+
+	repoDir := path.Join(cacheDir, "actions", "checkout")
+	assert.NoDirExists(t, path.Join(repoDir, ".git"))
+	assert.NoFileExists(t, path.Join(repoDir, "action.yml"))
+
+	gitRepo, err := gitImplementation{}.NewGitRepository(repoDir)
+	require.NoError(t, err)
+	refs, err := collectRefs(gitRepo, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []plumbing.ReferenceName{"refs/heads/main", "refs/tags/v1.0.0"}, refs)
+
+	head, err := gitRepo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, hash, head.Hash())
+}
+
+func TestPullWithGitImpl_WorktreeCacheLayout(t *testing.T) {
+	srcDir := t.TempDir()
+	initTestRepository(t, path.Join(srcDir, "actions", "checkout"))
+	cacheDir := t.TempDir()
+
+	flags := newTestPullFlags(cacheDir, false)
+	flags.SourceURL = "file://" + srcDir
+	flags.CacheLayout = CacheLayoutWorktree
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+
+	repoDir := path.Join(cacheDir, "actions", "checkout")
+	assert.DirExists(t, path.Join(repoDir, ".git"))
+	assert.FileExists(t, path.Join(repoDir, "action.yml"))
+}
@@ -1,21 +1,51 @@
 package src
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 // flags common to pull, push and sync operations
 type CommonFlags struct {
 	CacheDir, RepoName, RepoNameList, RepoNameListFile string
+	ContinueOnError                                    bool
+	IncludeRefs, ExcludeRefs                           []string
+	TagsSemver                                         string
+	KeepLatestMajors                                   int
+	SkipPrerelease                                     bool
+	Prune                                              bool
+	PruneThreshold                                     int
+	PruneMinRefs                                       int
+	MinTagAge                                          string
+	InsecureSkipTLSVerify                              bool
+
+	// repoNames is the repo list with wildcard and search entries expanded,
+	// set by Pull so that sync pushes the same repositories
+	repoNames []string
+	// manifest holds the per-repository options when --repo-name-list-file
+	// is a YAML or JSON manifest
+	manifest *manifest
 }
 
 func (f *CommonFlags) Init(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&f.CacheDir, "cache-dir", "", "Directory containing the repositories cache created by the `pull` command")
 	_ = cmd.MarkFlagRequired("cache-dir")
 
-	cmd.Flags().StringVar(&f.RepoName, "repo-name", "", "Single repository name to pull")
-	cmd.Flags().StringVar(&f.RepoNameList, "repo-name-list", "", "Comma delimited list of repository names to pull")
-	cmd.Flags().StringVar(&f.RepoNameListFile, "repo-name-list-file", "", "Path to file containing a list of repository names to pull")
+	cmd.Flags().StringVar(&f.RepoName, "repo-name", "", "Single repository name to pull. Nested names such as group/sub/repo are pushed to group/sub-repo unless given a destination")
+	cmd.Flags().StringVar(&f.RepoNameList, "repo-name-list", "", "Comma delimited list of repository names to pull. Nested names such as group/sub/repo are pushed to group/sub-repo unless given a destination")
+	cmd.Flags().StringVar(&f.RepoNameListFile, "repo-name-list-file", "", "Path to file containing a list of repository names to pull, or a YAML or JSON manifest (.yml, .yaml or .json) with options for each repository")
+	cmd.Flags().StringSliceVar(&f.IncludeRefs, "include-refs", nil, "Glob patterns of refs to sync, e.g. 'refs/tags/v*,refs/heads/main'. Prefix a pattern with '!' to exclude matching refs")
+	cmd.Flags().StringSliceVar(&f.ExcludeRefs, "exclude-refs", nil, "Glob patterns of refs not to sync, e.g. 'refs/heads/dependabot/*'")
+	cmd.Flags().StringVar(&f.TagsSemver, "tags-semver", "", "Only sync release tags matching this semver constraint, e.g. '>=3.0.0' or '>=3.0.0 <5'")
+	cmd.Flags().IntVar(&f.KeepLatestMajors, "keep-latest-majors", 0, "Only sync release tags from the latest N major versions (0 = all)")
+	cmd.Flags().BoolVar(&f.SkipPrerelease, "skip-prerelease", false, "Do not sync prerelease tags such as v2.0.0-beta.1")
+	cmd.Flags().StringVar(&f.MinTagAge, "min-tag-age", "", "Only sync tags published at least this long ago, e.g. '7d' or '36h'")
+	cmd.Flags().BoolVar(&f.Prune, "prune", false, "Delete branches and tags that no longer exist upstream from the cache (pull) and the destination (push)")
+	cmd.Flags().IntVar(&f.PruneThreshold, "prune-threshold", DefaultPruneThreshold, "Abort pruning a repository if more than this percentage of its refs would be deleted")
+	cmd.Flags().IntVar(&f.PruneMinRefs, "prune-min-refs", 0, "Prune up to this many refs from a repository even if that is more than --prune-threshold, as long as some refs are kept")
+	cmd.Flags().BoolVar(&f.InsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Do not verify the TLS certificates of the source and the GHES instance. Insecure, prefer --source-ca-file and --destination-ca-file")
+	cmd.Flags().BoolVar(&f.ContinueOnError, "continue-on-error", false, "Keep going when a repository fails, print a summary of every repository at the end and exit non-zero if any failed")
 }
 
 func (f *CommonFlags) Validate(reposRequired bool) Validations {
@@ -23,9 +53,29 @@ func (f *CommonFlags) Validate(reposRequired bool) Validations {
 	if reposRequired && !f.HasAtLeastOneRepoFlag() {
 		validations = append(validations, "one of --repo-name, --repo-name-list, --repo-name-list-file must be set")
 	}
+	if f.PruneThreshold < 0 || f.PruneThreshold > 100 {
+		validations = append(validations, "--prune-threshold must be a percentage between 0 and 100")
+	}
+	if f.PruneMinRefs < 0 {
+		validations = append(validations, "--prune-min-refs cannot be negative")
+	}
+	if f.MinTagAge != "" {
+		if _, err := parseAge(f.MinTagAge); err != nil {
+			validations = append(validations, "--min-tag-age "+err.Error())
+		}
+	}
+	if _, err := newRefSelection(f); err != nil {
+		validations = append(validations, err.Error())
+	}
 	return validations
 }
 
+// minTagAge returns the parsed --min-tag-age, or 0 when it isn't set.
+func (f *CommonFlags) minTagAge() time.Duration {
+	age, _ := parseAge(f.MinTagAge)
+	return age
+}
+
 func (f *CommonFlags) HasAtLeastOneRepoFlag() bool {
 	return f.RepoName != "" || f.RepoNameList != "" || f.RepoNameListFile != ""
 }
@@ -0,0 +1,156 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+)
+
+// endpoint is how requests reach the source or the destination: the git
+// credentials along with the TLS and proxy settings, which apply to git and API
+// requests alike.
+type endpoint struct {
+	auth     transport.AuthMethod
+	caBundle []byte
+	insecure bool
+	proxy    transport.ProxyOptions
+	// api is the transport for REST API requests, nil for http.DefaultTransport
+	api *http.Transport
+	// clientCert is presented to servers that ask for a client certificate
+	clientCert *tls.Certificate
+}
+
+// newEndpoint reads caFile and returns an endpoint trusting it in addition to
+// the system roots and connecting through proxy. Without a proxy the usual
+// HTTPS_PROXY and NO_PROXY environment variables apply. name is the flag
+// prefix, either `source` or `destination`.
+func newEndpoint(name, caFile, proxy string, insecure bool) (endpoint, error) {
+	var ep endpoint
+	if caFile == "" && proxy == "" && !insecure {
+		return ep, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{}
+	if caFile != "" {
+		caBundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return ep, errors.Wrapf(err, "error reading --%s-ca-file", name)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return ep, errors.Errorf("--%s-ca-file `%s` does not contain any PEM encoded certificates", name, caFile)
+		}
+		ep.caBundle = caBundle
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if insecure {
+		fmt.Printf("WARNING: --insecure-skip-tls-verify is set, the TLS certificates of the %s are not verified\n", name)
+		ep.insecure = true
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return ep, errors.Wrapf(err, "error parsing --%s-proxy", name)
+		}
+		ep.proxy = transport.ProxyOptions{URL: proxy}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	ep.api = t
+	return ep, nil
+}
+
+// withClientCertificate returns a copy of the endpoint presenting the client
+// certificate in certFile, with its private key in keyFile, to servers that
+// ask for one.
+func (e endpoint) withClientCertificate(certFile, keyFile string) (endpoint, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return e, errors.Wrap(err, "error loading --destination-client-cert and --destination-client-key")
+	}
+	if e.api == nil {
+		e.api = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		e.api = e.api.Clone()
+	}
+	if e.api.TLSClientConfig == nil {
+		e.api.TLSClientConfig = &tls.Config{}
+	}
+	e.api.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	e.clientCert = &cert
+	return e, nil
+}
+
+// clientCertKey is the context key of the client certificate that git HTTPS
+// requests made with the context present.
+type clientCertKey struct{}
+
+var installGitHTTPS sync.Once
+
+// gitContext returns ctx for the git requests to the endpoint. go-git takes CA
+// bundles and proxies with each request but has no such option for client
+// certificates, so the endpoint's certificate travels on the context instead.
+// The HTTPS transport go-git uses is replaced once with one that presents the
+// certificate of the request it connects for, so requests to the source and
+// the destination never see each other's certificate.
+func (e endpoint) gitContext(ctx context.Context) context.Context {
+	if e.clientCert == nil {
+		return ctx
+	}
+	installGitHTTPS.Do(func() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{GetClientCertificate: requestClientCertificate}
+		client.InstallProtocol("https", githttp.NewClient(&http.Client{Transport: t}))
+	})
+	return context.WithValue(ctx, clientCertKey{}, e.clientCert)
+}
+
+// requestClientCertificate returns the client certificate on the context of
+// the request being connected for, or none.
+func requestClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert, ok := info.Context().Value(clientCertKey{}).(*tls.Certificate); ok {
+		return cert, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// withAuth returns a copy of the endpoint using auth for git requests.
+func (e endpoint) withAuth(auth transport.AuthMethod) endpoint {
+	e.auth = auth
+	return e
+}
+
+// roundTripper returns the transport for API requests.
+func (e endpoint) roundTripper() http.RoundTripper {
+	if e.api == nil {
+		return http.DefaultTransport
+	}
+	return e.api
+}
+
+// validateProxy checks a --*-proxy flag value.
+func validateProxy(name, proxy string) Validations {
+	var validations Validations
+	if proxy == "" {
+		return validations
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		validations = append(validations, fmt.Sprintf("--%s-proxy must be a URL such as http://proxy.example.com:3128", name))
+	}
+	return validations
+}
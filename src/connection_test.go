@@ -0,0 +1,286 @@
+package src
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v43/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestNewEndpoint_CAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	_, err := (&http.Client{Transport: endpoint{}.roundTripper()}).Get(server.URL)
+	require.Error(t, err, "the test server's certificate is not trusted by default")
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caBundle, 0o600))
+
+	ep, err := newEndpoint("destination", caFile, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, caBundle, ep.caBundle, "git requests should trust the bundle too")
+	resp, err := (&http.Client{Transport: ep.roundTripper()}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestNewEndpoint_InsecureSkipTLSVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ep, err := newEndpoint("source", "", "", true)
+	require.NoError(t, err)
+	assert.True(t, ep.insecure)
+	resp, err := (&http.Client{Transport: ep.roundTripper()}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestNewEndpoint_Proxy(t *testing.T) {
+	var proxied, proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	proxyURL := "http://user:secret@" + proxy.Listener.Addr().String()
+	ep, err := newEndpoint("source", "", proxyURL, false)
+	require.NoError(t, err)
+	assert.Equal(t, proxyURL, ep.proxy.URL)
+
+	resp, err := (&http.Client{Transport: ep.roundTripper()}).Get("http://github.example.com/api/v3")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://github.example.com/api/v3", proxied)
+	assert.NotEmpty(t, proxyAuth, "the proxy credentials should be sent")
+}
+
+func TestNewEndpoint_Errors(t *testing.T) {
+	_, err := newEndpoint("destination", path.Join(t.TempDir(), "missing.pem"), "", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--destination-ca-file")
+
+	notPEM := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+	_, err = newEndpoint("destination", notPEM, "", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not contain any PEM encoded certificates")
+
+	assert.Empty(t, validateProxy("source", "http://proxy.example.com:3128"))
+	assert.Len(t, validateProxy("source", "proxy.example.com:3128"), 1)
+	assert.Len(t, validateProxy("source", "ftp://proxy.example.com"), 1)
+}
+
+func TestPushRefsInBatches_UsesEndpointSettings(t *testing.T) {
+	remote := &mockGitRemote{}
+	dest := endpoint{caBundle: []byte("bundle"), insecure: true}
+	dest.proxy.URL = "http://proxy.example.com:3128"
+
+	err := pushRefsInBatches(context.Background(), remote, []plumbing.ReferenceName{"refs/heads/main"}, 10, dest, "https://example.com/repo.git")
+	require.NoError(t, err)
+	require.NotNil(t, remote.pushOptions)
+	assert.Equal(t, []byte("bundle"), remote.pushOptions.CABundle)
+	assert.True(t, remote.pushOptions.InsecureSkipTLS)
+	assert.Equal(t, "http://proxy.example.com:3128", remote.pushOptions.ProxyOptions.URL)
+}
+
+// writeTestClientCertificate creates a CA and a client certificate signed by
+// it, writes the certificate and its key to dir and returns the CA pool.
+func writeTestClientCertificate(t *testing.T, dir string) (clientCAs *x509.CertPool, certFile, keyFile string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "actions-sync"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = path.Join(dir, "client.pem")
+	keyFile = path.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	clientCAs = x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	return clientCAs, certFile, keyFile
+}
+
+// newMTLSGitServer stands in for a GHES instance behind a reverse proxy that
+// requires client certificates from clientCAs. It serves the bare
+// repositories in root with git http-backend and answers API requests with an
+// empty object.
+func newMTLSGitServer(t *testing.T, root string, clientCAs *x509.CertPool) *httptest.Server {
+	t.Helper()
+
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	backend := &cgi.Handler{
+		Path: path.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		// REMOTE_USER lets http-backend accept pushes
+		Env: []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1", "REMOTE_USER=test"},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", backend)
+	mux.HandleFunc("/api/v3/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{}")
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEndpoint_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	clientCAs, certFile, keyFile := writeTestClientCertificate(t, dir)
+	destRoot := t.TempDir()
+	dest, err := git.PlainInit(path.Join(destRoot, "checkout.git"), true)
+	require.NoError(t, err)
+	server := newMTLSGitServer(t, destRoot, clientCAs)
+
+	caFile := path.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	withoutCert, err := newEndpoint("destination", caFile, "", false)
+	require.NoError(t, err)
+	withCert, err := withoutCert.withClientCertificate(certFile, keyFile)
+	require.NoError(t, err)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, err = newTokenHTTPClient(ts, withoutCert.roundTripper()).Get(server.URL + "/api/v3/")
+	require.Error(t, err, "the server requires a client certificate")
+	resp, err := newTokenHTTPClient(ts, withCert.roundTripper()).Get(server.URL + "/api/v3/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	repoDir := path.Join(t.TempDir(), "actions", "checkout")
+	initTestRepository(t, repoDir)
+	cloneURL := server.URL + "/checkout.git"
+	fullName := "actions/checkout"
+	ghRepo := &github.Repository{CloneURL: &cloneURL, FullName: &fullName}
+	flags := &PushFlags{PushOnlyFlags: PushOnlyFlags{DisableGitAuth: true}}
+
+	flags.destination = withoutCert
+	_, err = syncWithCachedRepository(context.Background(), flags, ghRepo, repoDir, nil, io.Discard, gitImplementation{})
+	require.Error(t, err, "git pushes need the client certificate too")
+
+	flags.destination = withCert
+	_, err = syncWithCachedRepository(context.Background(), flags, ghRepo, repoDir, nil, io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	_, err = dest.Reference("refs/heads/main", false)
+	assert.NoError(t, err)
+	_, err = dest.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err)
+}
+
+func TestEndpoint_ClientCertificateConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	clientCAs, certFile, keyFile := writeTestClientCertificate(t, dir)
+	destRoot := t.TempDir()
+	server := newMTLSGitServer(t, destRoot, clientCAs)
+
+	caFile := path.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	withoutCert, err := newEndpoint("source", caFile, "", false)
+	require.NoError(t, err)
+	withCert, err := withoutCert.withClientCertificate(certFile, keyFile)
+	require.NoError(t, err)
+
+	// requests without the certificate must keep failing while others present
+	// it, and the other way round
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	for i := range errs {
+		name := fmt.Sprintf("checkout-%d", i)
+		_, err := git.PlainInit(path.Join(destRoot, name+".git"), true)
+		require.NoError(t, err)
+		repoDir := path.Join(t.TempDir(), "actions", name)
+		initTestRepository(t, repoDir)
+		cloneURL := server.URL + "/" + name + ".git"
+		fullName := "actions/" + name
+		ghRepo := &github.Repository{CloneURL: &cloneURL, FullName: &fullName}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			flags := &PushFlags{PushOnlyFlags: PushOnlyFlags{DisableGitAuth: true}}
+			flags.destination = withoutCert
+			if i%2 == 0 {
+				flags.destination = withCert
+			}
+			_, errs[i] = syncWithCachedRepository(context.Background(), flags, ghRepo, repoDir, nil, io.Discard, gitImplementation{})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if i%2 == 0 {
+			assert.NoError(t, err, "push %d presents the client certificate", i)
+		} else {
+			assert.Error(t, err, "push %d has no client certificate", i)
+		}
+	}
+}
+
+func TestPushOnlyFlags_Validate_ClientCertificate(t *testing.T) {
+	f := PushOnlyFlags{BaseURL: "https://ghes.example.com", Token: "token", ClientCert: "client.pem"}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--destination-client-key")
+}
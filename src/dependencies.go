@@ -0,0 +1,363 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// maxActionDepth is how many directories deep below the root of a repository
+// action.yml files are looked for, enough for actions in subdirectories such
+// as `github/codeql-action/init`.
+const maxActionDepth = 3
+
+// pulledRefs tracks what has been pulled of a repository while following
+// dependencies, either every ref or only some pinned ones.
+type pulledRefs struct {
+	all  bool
+	refs map[string]bool
+}
+
+// dependencyGraph records which repositories use actions and reusable
+// workflows from which other repositories.
+type dependencyGraph struct {
+	roots []string
+	deps  map[string][]actionRef
+}
+
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{deps: map[string][]actionRef{}}
+}
+
+// add records that the repository nwo uses each of deps.
+func (g *dependencyGraph) add(nwo string, deps []actionRef) {
+	key := strings.ToLower(nwo)
+	seen := map[string]bool{}
+	for _, dep := range g.deps[key] {
+		seen[strings.ToLower(dep.String())] = true
+	}
+	for _, dep := range deps {
+		if !seen[strings.ToLower(dep.String())] {
+			seen[strings.ToLower(dep.String())] = true
+			g.deps[key] = append(g.deps[key], dep)
+		}
+	}
+	sort.Slice(g.deps[key], func(i, j int) bool {
+		return strings.ToLower(g.deps[key][i].String()) < strings.ToLower(g.deps[key][j].String())
+	})
+}
+
+// print writes the graph as a tree under each of the roots. Repositories that
+// use each other are marked as a cycle, and dependencies of a repository that
+// was already printed are not repeated.
+func (g *dependencyGraph) print(out io.Writer) {
+	fmt.Fprintln(out, "dependency graph:")
+	printed := map[string]bool{}
+	var visit func(nwo string, depth int, ancestors map[string]bool)
+	visit = func(nwo string, depth int, ancestors map[string]bool) {
+		key := strings.ToLower(nwo)
+		printed[key] = true
+		for _, dep := range g.deps[key] {
+			depKey := strings.ToLower(dep.nwo())
+			line := strings.Repeat("  ", depth) + dep.String()
+			switch {
+			case ancestors[depKey]:
+				fmt.Fprintln(out, line+" (cycle)")
+			case printed[depKey] && len(g.deps[depKey]) > 0:
+				fmt.Fprintln(out, line+" (see above)")
+			default:
+				fmt.Fprintln(out, line)
+				ancestors[depKey] = true
+				visit(dep.nwo(), depth+1, ancestors)
+				delete(ancestors, depKey)
+			}
+		}
+	}
+	for _, root := range g.roots {
+		if printed[strings.ToLower(root)] {
+			fmt.Fprintln(out, root+" (see above)")
+			continue
+		}
+		fmt.Fprintln(out, root)
+		visit(root, 1, map[string]bool{strings.ToLower(root): true})
+	}
+}
+
+// pullWithDependencies pulls repoNames and then, round by round, the
+// repositories whose actions and reusable workflows the pulled ones use at the
+// synced refs, until no new references turn up. Dependencies are pinned to the
+// refs that are used, and refs that were already pulled are not followed
+// again, which ends cycles. It returns every entry that was pulled.
+func pullWithDependencies(ctx context.Context, flags *PullFlags, auth transport.AuthMethod, repoNames []string, out io.Writer, gitimpl GitImplementation) ([]string, error) {
+	graph := newDependencyGraph()
+	pulled := map[string]*pulledRefs{}
+	var all []string
+	var results []repoResult
+	var pullErr error
+
+	round := repoNames
+	for _, repoName := range repoNames {
+		if spec, err := parseRepoSpec(repoName); err == nil {
+			graph.roots = append(graph.roots, spec.origin)
+			markPulled(pulled, spec.origin, spec.refs)
+		}
+	}
+	for len(round) > 0 {
+		all = append(all, round...)
+		roundResults, err := pullEachRepo(ctx, flags, auth, round, gitimpl)
+		results = append(results, roundResults...)
+		if err != nil {
+			if !flags.ContinueOnError {
+				return all, err
+			}
+			pullErr = err
+		}
+
+		var found []actionRef
+		for _, repoName := range round {
+			spec, err := parseRepoSpec(repoName)
+			if err != nil {
+				continue
+			}
+			dir := path.Join(flags.CacheDir, spec.dest)
+			if _, err := os.Stat(dir); err != nil {
+				// the pull failed and was skipped with --continue-on-error
+				continue
+			}
+			repoFlags, _, err := flags.forRepo(repoName, nil)
+			if err != nil {
+				return all, err
+			}
+			deps, err := repoDependencies(repoFlags, dir, spec.refs, out, gitimpl)
+			if err != nil {
+				return all, fmt.Errorf("could not read the dependencies of %s: %w", spec.origin, err)
+			}
+			graph.add(spec.origin, deps)
+			found = append(found, deps...)
+		}
+		round = dependencyEntries(pulled, found)
+	}
+
+	graph.print(out)
+	// every round is summarized together once the last one is done
+	if flags.ContinueOnError {
+		pullErr = summarizeRepos(results, pullErr)
+	}
+	return mergeRepoEntries(all), pullErr
+}
+
+// mergeRepoEntries merges entries with the same destination into one, so the
+// cached repository is pushed once with every ref that was pinned, or with
+// every ref when any of the entries doesn't pin refs.
+func mergeRepoEntries(entries []string) []string {
+	var order []string
+	specs := map[string]*repoSpec{}
+	for _, entry := range entries {
+		spec, err := parseRepoSpec(entry)
+		if err != nil {
+			order = append(order, entry)
+			continue
+		}
+		key := strings.ToLower(spec.dest)
+		merged, ok := specs[key]
+		if !ok {
+			specs[key] = spec
+			order = append(order, key)
+			continue
+		}
+		if len(merged.refs) == 0 || len(spec.refs) == 0 {
+			merged.refs = nil
+			continue
+		}
+		seen := map[string]bool{}
+		for _, ref := range merged.refs {
+			seen[ref] = true
+		}
+		for _, ref := range spec.refs {
+			if !seen[ref] {
+				seen[ref] = true
+				merged.refs = append(merged.refs, ref)
+			}
+		}
+	}
+
+	merged := make([]string, 0, len(order))
+	for _, key := range order {
+		if spec, ok := specs[key]; ok {
+			merged = append(merged, spec.entry())
+			continue
+		}
+		merged = append(merged, key)
+	}
+	return merged
+}
+
+// markPulled records that refs of the repository nwo were pulled, every ref
+// when refs is empty.
+func markPulled(pulled map[string]*pulledRefs, nwo string, refs []string) {
+	key := strings.ToLower(nwo)
+	p := pulled[key]
+	if p == nil {
+		p = &pulledRefs{refs: map[string]bool{}}
+		pulled[key] = p
+	}
+	if len(refs) == 0 {
+		p.all = true
+	}
+	for _, ref := range refs {
+		p.refs[ref] = true
+	}
+}
+
+// dependencyEntries returns repo list entries for the dependencies that
+// haven't been pulled yet, and marks them as pulled. References to a commit
+// SHA pull every ref of the repository, as the commit can't be fetched by
+// name.
+func dependencyEntries(pulled map[string]*pulledRefs, deps []actionRef) []string {
+	var order []string
+	names := map[string]string{}
+	pending := map[string][]string{}
+	for _, dep := range deps {
+		key := strings.ToLower(dep.nwo())
+		if p := pulled[key]; p != nil && (p.all || p.refs[dep.ref]) {
+			continue
+		}
+		if _, ok := names[key]; !ok {
+			order = append(order, key)
+			names[key] = dep.nwo()
+		}
+		if dep.isCommitSHA() {
+			markPulled(pulled, key, nil)
+			continue
+		}
+		markPulled(pulled, key, []string{dep.ref})
+		pending[key] = append(pending[key], dep.ref)
+	}
+
+	var entries []string
+	for _, key := range order {
+		if pulled[key].all {
+			entries = append(entries, names[key])
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s@%s", names[key], strings.Join(pending[key], ",")))
+	}
+	return entries
+}
+
+// repoDependencies returns what the action metadata files and workflows of the
+// repository cached at dir use, at each synced ref.
+func repoDependencies(flags *PullFlags, dir string, pins []string, out io.Writer, gitimpl GitImplementation) ([]actionRef, error) {
+	selection, err := newRepoRefSelection(&flags.CommonFlags, pins)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := gitimpl.NewGitRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := collectRefs(cached, selection)
+	if err != nil {
+		return nil, err
+	}
+	return scanRefDependencies(cached, refs, out)
+}
+
+// scanRefDependencies reads the action metadata files and reusable workflows of
+// repo at each of refs and returns the references they contain. Files that
+// aren't valid YAML are reported and skipped.
+func scanRefDependencies(repo GitRepository, refs []plumbing.ReferenceName, out io.Writer) ([]actionRef, error) {
+	hashes := map[plumbing.ReferenceName]plumbing.Hash{}
+	refIter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	err = refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			hashes[ref.Name()] = peelTag(repo, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []actionRef
+	scanned := map[plumbing.Hash]bool{}
+	for _, name := range refs {
+		hash, ok := hashes[name]
+		if !ok || scanned[hash] {
+			continue
+		}
+		scanned[hash] = true
+		// refs that don't point at a commit have no files to scan
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			continue
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		err = walkActionFiles(tree, "", 0, func(filePath string, content []byte) {
+			// other workflows only run in the repository itself
+			if isWorkflowFile(filePath) && !isReusableWorkflow(content) {
+				return
+			}
+			found, err := findUses(content)
+			if err != nil {
+				fmt.Fprintf(out, "WARNING: skipping %s at %s: %s\n", filePath, name.Short(), err)
+				return
+			}
+			deps = append(deps, found...)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// walkActionFiles calls fn with the content of each action metadata file and
+// workflow in tree, whose path in the repository is prefix.
+func walkActionFiles(tree *object.Tree, prefix string, depth int, fn func(filePath string, content []byte)) error {
+	for i := range tree.Entries {
+		entry := &tree.Entries[i]
+		filePath := path.Join(prefix, entry.Name)
+		if entry.Mode == filemode.Dir {
+			if skipActionDir(entry.Name) || depth >= maxActionDepth {
+				continue
+			}
+			subtree, err := tree.Tree(entry.Name)
+			if err != nil {
+				return err
+			}
+			if err := walkActionFiles(subtree, filePath, depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Mode.IsFile() || !isActionFile(filePath) {
+			continue
+		}
+		file, err := tree.TreeEntryFile(entry)
+		if err != nil {
+			return err
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		fn(filePath, []byte(content))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initTestActionRepository creates a repository at dir with the files, whose
+// default branch is main and which is tagged v1.
+func initTestActionRepository(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	repo, err := git.PlainInitWithOptions(dir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(content), 0o644))
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+	}
+	hash, err := wt.Commit("add action", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v1", hash, nil)
+	require.NoError(t, err)
+}
+
+func TestPullWithDependencies(t *testing.T) {
+	root := t.TempDir()
+	initTestActionRepository(t, path.Join(root, "myorg", "composite"), map[string]string{
+		"action.yml": "runs:\n  using: composite\n  steps:\n    - uses: actions/setup@v1\n    - uses: ./local\n    - uses: docker://alpine\n",
+		// CI workflows only run in the repository itself, so they aren't followed
+		".github/workflows/ci.yml": "on: push\njobs:\n  test:\n    runs-on: ubuntu-latest\n    steps:\n      - uses: other/ci@v1\n",
+	})
+	initTestActionRepository(t, path.Join(root, "actions", "setup"), map[string]string{
+		"action.yml":                  "runs:\n  using: composite\n  steps:\n    - uses: myorg/composite@v1\n",
+		"lint/action.yml":             "runs:\n  using: composite\n  steps:\n    - uses: other/lint@main\n",
+		".github/workflows/reuse.yml": "on:\n  workflow_call:\njobs:\n  call:\n    uses: other/workflows/.github/workflows/build.yml@v1\n",
+	})
+	initTestActionRepository(t, path.Join(root, "other", "lint"), map[string]string{"action.yml": "runs:\n  using: node20\n  main: index.js\n"})
+	initTestActionRepository(t, path.Join(root, "other", "workflows"), map[string]string{".github/workflows/build.yml": "on: workflow_call\njobs: {}\n"})
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.SourceURL = "file://" + root
+	var out bytes.Buffer
+	pulled, err := pullWithDependencies(context.Background(), flags, nil, []string{"myorg/composite"}, &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myorg/composite", "actions/setup@v1", "other/workflows@v1", "other/lint@main"}, pulled)
+
+	repoNames, err := getRepoNamesFromCacheDir(&flags.CommonFlags)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"myorg/composite", "actions/setup", "other/lint", "other/workflows"}, repoNames)
+
+	assert.Equal(t, strings.Join([]string{
+		"dependency graph:",
+		"myorg/composite",
+		"  actions/setup@v1",
+		"    myorg/composite@v1 (cycle)",
+		"    other/lint@main",
+		"    other/workflows@v1",
+		"",
+	}, "\n"), out.String()[strings.Index(out.String(), "dependency graph:"):])
+}
+
+func TestPullWithDependencies_ContinueOnErrorSummarizesEveryRound(t *testing.T) {
+	root := t.TempDir()
+	initTestActionRepository(t, path.Join(root, "myorg", "composite"), map[string]string{
+		"action.yml": "runs:\n  using: composite\n  steps:\n    - uses: other/missing@v1\n",
+	})
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.SourceURL = "file://" + root
+	flags.ContinueOnError = true
+	pulled, err := pullWithDependencies(context.Background(), flags, nil, []string{"myorg/composite"}, io.Discard, gitImplementation{})
+	require.EqualError(t, err, "1 of 2 repositories failed")
+	assert.Equal(t, []string{"myorg/composite", "other/missing@v1"}, pulled)
+}
+
+func TestDependencyEntries(t *testing.T) {
+	pulled := map[string]*pulledRefs{}
+	markPulled(pulled, "actions/checkout", nil)
+	markPulled(pulled, "actions/setup-go", []string{"v4"})
+
+	refs := []actionRef{
+		{owner: "actions", repo: "checkout", ref: "v3"},
+		{owner: "actions", repo: "setup-go", ref: "v4"},
+		{owner: "actions", repo: "setup-go", ref: "v5"},
+		{owner: "actions", repo: "cache", ref: "v3"},
+		{owner: "actions", repo: "cache", ref: "v4"},
+		{owner: "github", repo: "codeql-action", path: "init", ref: "8a470fddafa5cbb6266ee11b37ef4d8aae19c571"},
+	}
+	assert.Equal(t, []string{"actions/setup-go@v5", "actions/cache@v3,v4", "github/codeql-action"}, dependencyEntries(pulled, refs))
+	assert.Empty(t, dependencyEntries(pulled, refs), "refs are only pulled once")
+}
+
+func TestMergeRepoEntries(t *testing.T) {
+	assert.Equal(t, []string{
+		"actions/setup-go@v4,v5",
+		"actions/cache",
+		"group/sub/lint@main:myorg/lint",
+		"https://gitlab.example.com/group/repo.git@v1,v2=>myorg/repo",
+	}, mergeRepoEntries([]string{
+		"actions/setup-go@v4",
+		"actions/cache@v3",
+		"group/sub/lint@main:myorg/lint",
+		"actions/setup-go@v5,v4",
+		"https://gitlab.example.com/group/repo.git@v1=>myorg/repo",
+		"actions/cache",
+		"https://gitlab.example.com/group/repo.git@v2=>myorg/repo",
+	}))
+}
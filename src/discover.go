@@ -0,0 +1,245 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type DiscoverFlags struct {
+	Dirs           []string
+	Output         string
+	DestinationURL string
+	Token          string
+	TokenFile      string
+	TokenCommand   string
+	CAFile         string
+	CacheDir       string
+	AppendTo       string
+}
+
+func (f *DiscoverFlags) Init(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.Dirs, "dir", nil, "Directories or git checkouts to scan for workflows and action.yml files")
+	cmd.Flags().StringVar(&f.Output, "output", "", "Path of the repo list file to write, for use with --repo-name-list-file (default: stdout, unless checking what is missing)")
+	cmd.Flags().StringVar(&f.DestinationURL, "destination-url", "", "URL of a GHES instance whose workflows to scan, reporting the actions they use that haven't been synced to it")
+	cmd.Flags().StringVar(&f.Token, "destination-token", "", "Token to read the repositories of every organization on the GHES instance")
+	cmd.Flags().StringVar(&f.TokenFile, "destination-token-file", "", "Path to a file containing the destination token. The token can also be set with the "+DestinationTokenEnv+" environment variable")
+	cmd.Flags().StringVar(&f.TokenCommand, "destination-token-command", "", "Command that prints the destination token to stdout")
+	cmd.Flags().StringVar(&f.CAFile, "destination-ca-file", "", "Path to a PEM bundle of CA certificates to trust for the GHES instance, in addition to the system ones")
+	cmd.Flags().StringVar(&f.CacheDir, "cache-dir", "", "Also report the actions in use that are missing from this cache directory")
+	cmd.Flags().StringVar(&f.AppendTo, "append-to", "", "Append the actions that are missing from the cache or the GHES instance to this repo list file")
+}
+
+func (f *DiscoverFlags) Validate() Validations {
+	var validations Validations
+	if len(f.Dirs) == 0 && f.DestinationURL == "" {
+		validations = append(validations, "one of --dir or --destination-url must be set")
+	}
+	tokens := f.tokenOptions()
+	validations = append(validations, tokens.Validate()...)
+	if f.DestinationURL != "" {
+		if !strings.HasPrefix(strings.ToLower(f.DestinationURL), "https://") {
+			validations = append(validations, "--destination-url must be an https:// URL")
+		}
+		if !tokens.IsSet() {
+			validations = append(validations, "--destination-token must be set (or --destination-token-file, --destination-token-command or "+DestinationTokenEnv+") with --destination-url")
+		}
+	}
+	if f.AppendTo != "" && f.DestinationURL == "" && f.CacheDir == "" {
+		validations = append(validations, "--append-to requires --destination-url or --cache-dir to find what is missing")
+	}
+	return validations
+}
+
+// tokenOptions returns the ways the destination token may have been supplied.
+func (f *DiscoverFlags) tokenOptions() tokenOptions {
+	return tokenOptions{prefix: "destination", token: f.Token, file: f.TokenFile, command: f.TokenCommand, env: DestinationTokenEnv}
+}
+
+// checksMissing reports whether the actions in use are checked against the
+// cache or the destination.
+func (f *DiscoverFlags) checksMissing() bool {
+	return f.DestinationURL != "" || f.CacheDir != ""
+}
+
+// actionUsage collects the refs that each repository's actions and reusable
+// workflows are used at.
+type actionUsage struct {
+	names map[string]string
+	refs  map[string]map[string]bool
+}
+
+func newActionUsage() *actionUsage {
+	return &actionUsage{names: map[string]string{}, refs: map[string]map[string]bool{}}
+}
+
+// add records that ref is used.
+func (u *actionUsage) add(ref actionRef) {
+	key := strings.ToLower(ref.nwo())
+	if _, ok := u.names[key]; !ok {
+		u.names[key] = ref.nwo()
+		u.refs[key] = map[string]bool{}
+	}
+	u.refs[key][ref.ref] = true
+}
+
+// len returns the number of repositories used.
+func (u *actionUsage) len() int {
+	return len(u.names)
+}
+
+// entries returns a repo list entry for each repository, pinned to the refs
+// that are used, sorted by name. Commits can't be pinned by SHA, so a
+// repository used at a commit gets an entry for every ref, preceded by a
+// comment naming the commits.
+func (u *actionUsage) entries() []string {
+	keys := make([]string, 0, len(u.names))
+	for key := range u.names {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var entries []string
+	for _, key := range keys {
+		var refs, commits []string
+		for ref := range u.refs[key] {
+			if commitSHARegExp.MatchString(strings.ToLower(ref)) {
+				commits = append(commits, ref)
+			} else {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+		sort.Strings(commits)
+		if len(commits) > 0 {
+			entries = append(entries, fmt.Sprintf("# %s is used at commit %s", u.names[key], strings.Join(commits, ", ")))
+			entries = append(entries, u.names[key])
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s@%s", u.names[key], strings.Join(refs, ",")))
+	}
+	return entries
+}
+
+// write writes the repo list in the format read by --repo-name-list-file,
+// starting with a comment saying where it came from.
+func (u *actionUsage) write(w io.Writer, source string) error {
+	lines := append([]string{fmt.Sprintf("# actions used by %s", source)}, u.entries()...)
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func Discover(ctx context.Context, flags *DiscoverFlags) error {
+	usage := newActionUsage()
+	sources := append([]string{}, flags.Dirs...)
+	for _, dir := range flags.Dirs {
+		if err := scanDirUses(dir, usage, os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	var client *github.Client
+	if flags.DestinationURL != "" {
+		ts, err := flags.tokenOptions().TokenSource()
+		if err != nil {
+			return err
+		}
+		destination, err := newEndpoint("destination", flags.CAFile, "", false)
+		if err != nil {
+			return err
+		}
+		client, err = github.NewEnterpriseClient(flags.DestinationURL, flags.DestinationURL, newTokenHTTPClient(ts, destination.roundTripper()))
+		if err != nil {
+			return errors.Wrap(err, "error creating enterprise client")
+		}
+		if err := scanEnterpriseUses(ctx, client, usage, os.Stderr); err != nil {
+			return err
+		}
+		sources = append(sources, flags.DestinationURL)
+	}
+	source := strings.Join(sources, ", ")
+	fmt.Fprintf(os.Stderr, "found %d repositories used in %s\n", usage.len(), source)
+
+	if !flags.checksMissing() || flags.Output != "" {
+		if err := writeActionUsage(flags.Output, usage, source); err != nil {
+			return err
+		}
+	}
+	if !flags.checksMissing() {
+		return nil
+	}
+
+	missing, err := findMissingActions(ctx, usage, flags.CacheDir, client)
+	if err != nil {
+		return err
+	}
+	printMissingActions(os.Stdout, missing)
+	if flags.AppendTo != "" {
+		added, err := appendMissingActions(flags.AppendTo, missing, source)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "added %d repositories to %s\n", added, flags.AppendTo)
+	}
+	return nil
+}
+
+// writeActionUsage writes the repo list to the output file, or stdout when
+// output is empty.
+func writeActionUsage(output string, usage *actionUsage, source string) error {
+	if output == "" {
+		return usage.write(os.Stdout, source)
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return errors.Wrapf(err, "error creating `%s`", output)
+	}
+	if err := usage.write(file, source); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "error writing `%s`", output)
+	}
+	return file.Close()
+}
+
+// scanDirUses adds the references in the workflows and action.yml files under
+// dir to usage. Files that aren't valid YAML are reported to warnings and
+// skipped.
+func scanDirUses(dir string, usage *actionUsage, warnings io.Writer) error {
+	return filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "error scanning `%s`", dir)
+		}
+		if entry.IsDir() {
+			if filePath != dir && skipActionDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || !isActionFile(filepath.ToSlash(rel)) {
+			return nil
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "error reading `%s`", filePath)
+		}
+		refs, err := findUses(content)
+		if err != nil {
+			fmt.Fprintf(warnings, "WARNING: skipping %s: %s\n", filePath, err)
+			return nil
+		}
+		for _, ref := range refs {
+			usage.add(ref)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"app/.github/workflows/ci.yml": `
+on: push
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+      - uses: ./.github/actions/local
+      - uses: docker://alpine:3.19
+  reuse:
+    uses: octo-org/workflows/.github/workflows/test.yml@main
+`,
+		"app/.github/actions/local/action.yml": "runs:\n  using: composite\n  steps:\n    - uses: actions/checkout@v3\n",
+		"lib/.github/workflows/release.yaml":   "on: push\njobs:\n  release:\n    steps:\n      - uses: actions/checkout@8a470fddafa5cbb6266ee11b37ef4d8aae19c571\n      - uses: github/codeql-action/init@v3\n",
+		"lib/node_modules/dep/action.yml":      "runs:\n  steps:\n    - uses: ignored/dep@v1\n",
+		"lib/.github/workflows/broken.yml":     "jobs: [",
+		"lib/README.md":                        "uses: not/this@v1\n",
+	})
+	output := path.Join(t.TempDir(), "repos.txt")
+
+	err := Discover(context.Background(), &DiscoverFlags{Dirs: []string{dir}, Output: output})
+	require.NoError(t, err)
+
+	repoNames, err := getRepoNamesFromFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout", "actions/setup-go@v5", "github/codeql-action@v3", "octo-org/workflows@main"}, repoNames)
+	content, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "# actions/checkout is used at commit 8a470fddafa5cbb6266ee11b37ef4d8aae19c571")
+
+	for _, repoName := range repoNames {
+		_, err := parseRepoSpec(repoName)
+		assert.NoError(t, err, repoName)
+	}
+}
+
+func TestActionUsage_Entries(t *testing.T) {
+	usage := newActionUsage()
+	for _, uses := range []string{"actions/checkout@v4", "Actions/Checkout@v3", "actions/checkout@v4", "actions/cache@v4"} {
+		ref, ok := parseActionRef(uses)
+		require.True(t, ok)
+		usage.add(ref)
+	}
+	assert.Equal(t, []string{"actions/cache@v4", "actions/checkout@v3,v4"}, usage.entries())
+
+	var out bytes.Buffer
+	require.NoError(t, usage.write(&out, "./src"))
+	assert.Equal(t, "# actions used by ./src\nactions/cache@v4\nactions/checkout@v3,v4\n", out.String())
+}
+
+func TestScanDirUses_MissingDir(t *testing.T) {
+	err := scanDirUses(path.Join(t.TempDir(), "missing"), newActionUsage(), &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,243 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+)
+
+// missingAction is a ref of an action or reusable workflow that is used but
+// hasn't been synced.
+type missingAction struct {
+	ref                        actionRef
+	fromCache, fromDestination bool
+}
+
+// scanEnterpriseUses adds the references in the workflows and root action.yml
+// files on the default branch of every repository of every organization on the
+// instance to usage. Archived repositories are skipped.
+func scanEnterpriseUses(ctx context.Context, client *github.Client, usage *actionUsage, warnings io.Writer) error {
+	orgOpts := &github.OrganizationsListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		orgs, _, err := client.Organizations.ListAll(ctx, orgOpts)
+		if err != nil {
+			return errors.Wrap(err, "error listing organizations")
+		}
+		if len(orgs) == 0 {
+			return nil
+		}
+		for _, org := range orgs {
+			if err := scanOrgUses(ctx, client, org.GetLogin(), usage, warnings); err != nil {
+				return err
+			}
+		}
+		orgOpts.Since = orgs[len(orgs)-1].GetID()
+	}
+}
+
+// scanOrgUses adds the references in the repositories of org to usage.
+func scanOrgUses(ctx context.Context, client *github.Client, org string, usage *actionUsage, warnings io.Writer) error {
+	opts := &github.RepositoryListByOrgOptions{Type: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		repos, resp, err := client.Repositories.ListByOrg(ctx, org, opts)
+		if err != nil {
+			return errors.Wrapf(err, "error listing the repositories of `%s`", org)
+		}
+		for _, repo := range repos {
+			if repo.GetArchived() {
+				continue
+			}
+			if err := scanRepoUses(ctx, client, repo, usage, warnings); err != nil {
+				return err
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// scanRepoUses adds the references in the workflows and root action.yml of
+// the repository's default branch to usage.
+func scanRepoUses(ctx context.Context, client *github.Client, repo *github.Repository, usage *actionUsage, warnings io.Writer) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	opts := &github.RepositoryContentGetOptions{Ref: repo.GetDefaultBranch()}
+
+	var files []string
+	for _, dir := range []string{"", ".github/workflows"} {
+		_, entries, resp, err := client.Repositories.GetContents(ctx, owner, name, dir, opts)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				// empty repositories and ones without workflows
+				continue
+			}
+			return errors.Wrapf(err, "error listing `%s` in %s", dir, repo.GetFullName())
+		}
+		for _, entry := range entries {
+			if entry.GetType() == "file" && isActionFile(entry.GetPath()) {
+				files = append(files, entry.GetPath())
+			}
+		}
+	}
+
+	for _, filePath := range files {
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, name, filePath, opts)
+		if err != nil {
+			return errors.Wrapf(err, "error reading `%s` in %s", filePath, repo.GetFullName())
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return errors.Wrapf(err, "error decoding `%s` in %s", filePath, repo.GetFullName())
+		}
+		refs, err := findUses([]byte(content))
+		if err != nil {
+			fmt.Fprintf(warnings, "WARNING: skipping %s in %s: %s\n", filePath, repo.GetFullName(), err)
+			continue
+		}
+		for _, ref := range refs {
+			usage.add(ref)
+		}
+	}
+	return nil
+}
+
+// findMissingActions checks each used ref against the cache, when cacheDir is
+// set, and the destination, when client is set, and returns those missing from
+// either.
+func findMissingActions(ctx context.Context, usage *actionUsage, cacheDir string, client *github.Client) ([]missingAction, error) {
+	var missing []missingAction
+	cached := map[string]*git.Repository{}
+	for _, ref := range usage.refList() {
+		m := missingAction{ref: ref}
+		if cacheDir != "" {
+			m.fromCache = !cachedRefExists(cacheDir, ref, cached)
+		}
+		if client != nil {
+			_, resp, err := client.Repositories.GetCommitSHA1(ctx, ref.owner, ref.repo, ref.ref, "")
+			if err != nil {
+				if resp == nil || (resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusUnprocessableEntity) {
+					return nil, errors.Wrapf(err, "error looking up %s on the destination", ref)
+				}
+				m.fromDestination = true
+			}
+		}
+		if m.fromCache || m.fromDestination {
+			missing = append(missing, m)
+		}
+	}
+	return missing, nil
+}
+
+// cachedRefExists reports whether the ref is a branch, tag or commit of the
+// repository in the cache. Opened repositories are kept in cached.
+func cachedRefExists(cacheDir string, ref actionRef, cached map[string]*git.Repository) bool {
+	repo, ok := cached[ref.nwo()]
+	if !ok {
+		repo, _ = git.PlainOpen(path.Join(cacheDir, ref.nwo()))
+		cached[ref.nwo()] = repo
+	}
+	if repo == nil {
+		return false
+	}
+	if ref.isCommitSHA() {
+		_, err := repo.CommitObject(plumbing.NewHash(strings.ToLower(ref.ref)))
+		return err == nil
+	}
+	for _, name := range []plumbing.ReferenceName{plumbing.NewTagReferenceName(ref.ref), plumbing.NewBranchReferenceName(ref.ref)} {
+		if _, err := repo.Reference(name, false); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// printMissingActions writes a line for each missing ref saying where it is
+// missing from.
+func printMissingActions(out io.Writer, missing []missingAction) {
+	if len(missing) == 0 {
+		fmt.Fprintln(out, "every action in use has been synced")
+		return
+	}
+	fmt.Fprintf(out, "%d actions in use have not been synced:\n", len(missing))
+	for _, m := range missing {
+		var where []string
+		if m.fromCache {
+			where = append(where, "the cache")
+		}
+		if m.fromDestination {
+			where = append(where, "the destination")
+		}
+		fmt.Fprintf(out, "  %s is missing from %s\n", m.ref, strings.Join(where, " and "))
+	}
+}
+
+// appendMissingActions appends entries for the missing refs to the repo list
+// file, leaving out refs the file already lists. It returns the number of
+// entries added.
+func appendMissingActions(file string, missing []missingAction, source string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrapf(err, "error reading `%s`", file)
+	}
+	listed := map[string]*pulledRefs{}
+	for _, entry := range filterEntries(strings.Split(string(data), "\n")) {
+		if spec, err := parseRepoSpec(entry); err == nil {
+			markPulled(listed, spec.origin, spec.refs)
+		}
+	}
+
+	usage := newActionUsage()
+	for _, m := range missing {
+		if p := listed[strings.ToLower(m.ref.nwo())]; p != nil && (p.all || p.refs[m.ref.ref]) {
+			continue
+		}
+		usage.add(m.ref)
+	}
+	if usage.len() == 0 {
+		return 0, nil
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error opening `%s`", file)
+	}
+	// start on a new line if the file doesn't end with one
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		_, _ = fmt.Fprintln(f)
+	}
+	if err := usage.write(f, source); err != nil {
+		f.Close()
+		return 0, errors.Wrapf(err, "error writing `%s`", file)
+	}
+	return usage.len(), f.Close()
+}
+
+// refList returns every used ref, sorted by repository and ref.
+func (u *actionUsage) refList() []actionRef {
+	var refs []actionRef
+	for key, name := range u.names {
+		owner, repo, _ := strings.Cut(name, "/")
+		for ref := range u.refs[key] {
+			refs = append(refs, actionRef{owner: owner, repo: repo, ref: ref})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := strings.ToLower(refs[i].nwo()), strings.ToLower(refs[j].nwo())
+		if a != b {
+			return a < b
+		}
+		return refs[i].ref < refs[j].ref
+	})
+	return refs
+}
@@ -0,0 +1,106 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGHESWorkflows serves one organization whose `app` repository has an
+// action.yml and a workflow, and whose destination has actions/checkout@v4.
+func newFakeGHESWorkflows(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	file := func(w http.ResponseWriter, filePath, content string) {
+		fmt.Fprintf(w, `{"type": "file", "path": %q, "encoding": "base64", "content": %q}`, filePath, base64.StdEncoding.EncodeToString([]byte(content)))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/organizations":
+			if r.URL.Query().Get("since") == "" {
+				fmt.Fprint(w, `[{"login": "myorg", "id": 7}]`)
+				return
+			}
+			assert.Equal(t, "7", r.URL.Query().Get("since"))
+			fmt.Fprint(w, `[]`)
+		case "/api/v3/orgs/myorg/repos":
+			fmt.Fprint(w, `[
+				{"name": "app", "full_name": "myorg/app", "owner": {"login": "myorg"}, "default_branch": "trunk"},
+				{"name": "old", "full_name": "myorg/old", "owner": {"login": "myorg"}, "archived": true},
+				{"name": "empty", "full_name": "myorg/empty", "owner": {"login": "myorg"}, "default_branch": "main"}
+			]`)
+		case "/api/v3/repos/myorg/app/contents/":
+			assert.Equal(t, "trunk", r.URL.Query().Get("ref"), "the default branch should be read")
+			fmt.Fprint(w, `[{"type": "file", "path": "README.md"}, {"type": "file", "path": "action.yml"}, {"type": "dir", "path": ".github"}]`)
+		case "/api/v3/repos/myorg/app/contents/.github/workflows":
+			fmt.Fprint(w, `[{"type": "file", "path": ".github/workflows/ci.yml"}]`)
+		case "/api/v3/repos/myorg/app/contents/action.yml":
+			file(w, "action.yml", "runs:\n  using: composite\n  steps:\n    - uses: actions/setup-go@v5\n")
+		case "/api/v3/repos/myorg/app/contents/.github/workflows/ci.yml":
+			file(w, ".github/workflows/ci.yml", "on: push\njobs:\n  build:\n    steps:\n      - uses: actions/checkout@v4\n      - uses: ./local\n")
+		case "/api/v3/repos/actions/checkout/commits/v4":
+			fmt.Fprint(w, "8a470fddafa5cbb6266ee11b37ef4d8aae19c571")
+		default:
+			if r.URL.Path == "/api/v3/repos/myorg/old/contents/" {
+				t.Errorf("archived repositories should be skipped")
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestScanEnterpriseUses(t *testing.T) {
+	server := newFakeGHESWorkflows(t)
+	client := newTestGitHubClient(t, server.URL)
+
+	usage := newActionUsage()
+	require.NoError(t, scanEnterpriseUses(context.Background(), client, usage, &bytes.Buffer{}))
+	assert.Equal(t, []string{"actions/checkout@v4", "actions/setup-go@v5"}, usage.entries())
+
+	cacheDir := t.TempDir()
+	initTestActionRepository(t, path.Join(cacheDir, "actions", "checkout"), map[string]string{"action.yml": "name: checkout\n"})
+	missing, err := findMissingActions(context.Background(), usage, cacheDir, client)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	printMissingActions(&out, missing)
+	assert.Equal(t, "2 actions in use have not been synced:\n"+
+		"  actions/checkout@v4 is missing from the cache\n"+
+		"  actions/setup-go@v5 is missing from the cache and the destination\n", out.String())
+
+	listFile := path.Join(t.TempDir(), "repos.txt")
+	require.NoError(t, os.WriteFile(listFile, []byte("actions/checkout@v4"), 0o644))
+	added, err := appendMissingActions(listFile, missing, server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added, "entries already in the file are left out")
+	repoNames, err := getRepoNamesFromFile(listFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout@v4", "actions/setup-go@v5"}, repoNames)
+
+	added, err = appendMissingActions(listFile, missing, server.URL)
+	require.NoError(t, err)
+	assert.Zero(t, added)
+}
+
+func TestDiscoverFlags_Validate(t *testing.T) {
+	assert.Len(t, (&DiscoverFlags{}).Validate(), 1)
+	assert.Empty(t, (&DiscoverFlags{Dirs: []string{"."}}).Validate())
+	assert.Empty(t, (&DiscoverFlags{DestinationURL: "https://ghes.example.com", Token: "token", AppendTo: "repos.txt"}).Validate())
+
+	validations := (&DiscoverFlags{DestinationURL: "http://ghes.example.com"}).Validate()
+	assert.Len(t, validations, 2)
+
+	validations = (&DiscoverFlags{Dirs: []string{"."}, AppendTo: "repos.txt"}).Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--append-to")
+}
@@ -0,0 +1,54 @@
+package src
+
+import (
+	"context"
+
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+)
+
+// cacheConfigSection is the section of a cached repository's git config where
+// pull records what it learned about the source repository.
+const cacheConfigSection = "actions-sync"
+
+// lookupVisibility returns whether the source repository nwo is public,
+// private or internal, for pull to record in the cached repository so that
+// push can create the destination repository with the same visibility.
+func lookupVisibility(ctx context.Context, client *github.Client, nwo string) (string, error) {
+	owner, name, err := splitNwo(nwo)
+	if err != nil {
+		return "", err
+	}
+	ghRepo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return "", errors.Wrapf(err, "error looking up `%s`", nwo)
+	}
+	visibility := ghRepo.GetVisibility()
+	if visibility == "" {
+		visibility = "public"
+		if ghRepo.GetPrivate() {
+			visibility = "private"
+		}
+	}
+	return visibility, nil
+}
+
+// setCachedVisibility records the source visibility in a cached repository.
+func setCachedVisibility(repo GitRepository, visibility string) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Raw.Section(cacheConfigSection).SetOption("visibility", visibility)
+	return repo.SetConfig(cfg)
+}
+
+// cachedVisibility returns the source visibility recorded in a cached
+// repository, or "" when the repository wasn't pulled from a GHES source.
+func cachedVisibility(repo GitRepository) string {
+	cfg, err := repo.Config()
+	if err != nil {
+		return ""
+	}
+	return cfg.Raw.Section(cacheConfigSection).Option("visibility")
+}
@@ -0,0 +1,108 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupVisibility(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/myorg/internal":
+			fmt.Fprint(w, `{"private": true, "visibility": "internal"}`)
+		case "/api/v3/repos/myorg/private":
+			fmt.Fprint(w, `{"private": true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := newTestGitHubClient(t, server.URL)
+
+	for _, visibility := range []string{"internal", "private"} {
+		dir := path.Join(t.TempDir(), "myorg", visibility)
+		initTestRepository(t, dir)
+		repo, err := gitImplementation{}.NewGitRepository(dir)
+		require.NoError(t, err)
+		assert.Empty(t, cachedVisibility(repo))
+
+		found, err := lookupVisibility(context.Background(), client, "myorg/"+visibility)
+		require.NoError(t, err)
+		assert.Equal(t, visibility, found)
+		require.NoError(t, setCachedVisibility(repo, found))
+		reopened, err := gitImplementation{}.NewGitRepository(dir)
+		require.NoError(t, err)
+		assert.Equal(t, visibility, cachedVisibility(reopened))
+	}
+
+	_, err := lookupVisibility(context.Background(), client, "myorg/missing")
+	require.Error(t, err)
+}
+
+func TestPullWithGitImpl_RecordsVisibilityBeforeFetching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"private": true}`)
+	}))
+	defer server.Close()
+	repo := &fakePullRepo{fetchErr: errors.New("connection reset")}
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.sourceClient = newTestGitHubClient(t, server.URL)
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "myorg/private", io.Discard, &fakePullGitImpl{repo: repo})
+	require.Error(t, err)
+	assert.Equal(t, "private", cachedVisibility(repo), "a failed fetch leaves the clone marked private")
+}
+
+func TestPushWithGitImpl_EnterpriseSourceDefaultsToPrivate(t *testing.T) {
+	f := &fakeGitHub{repoExists: false}
+	client := f.start(t)
+	cacheDir := t.TempDir()
+	initTestRepository(t, path.Join(cacheDir, "my-org", "my-repo"))
+	flags := &PushFlags{
+		CommonFlags:   CommonFlags{CacheDir: cacheDir},
+		PushOnlyFlags: PushOnlyFlags{GitHubApp: true, DisableGitAuth: true, enterpriseSource: true},
+	}
+
+	var out strings.Builder
+	// the push itself fails, as the fake clone URL can't be reached
+	_ = PushWithGitImpl(context.Background(), flags, "my-org/my-repo", &out, client, gitImplementation{})
+	assert.True(t, f.created)
+	assert.Equal(t, "private", f.createdVis)
+	assert.Contains(t, out.String(), "no visibility is recorded")
+}
+
+func TestGetOrCreateGitHubRepo_SourceVisibility(t *testing.T) {
+	f := &fakeGitHub{repoExists: false}
+	client := f.start(t)
+
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "my-repo", "my-org", true, "private", io.Discard)
+	require.NoError(t, err)
+	assert.True(t, f.created)
+	assert.Equal(t, "private", f.createdVis, "the source visibility should be kept")
+}
+
+func TestPullOnlyFlags_Validate_SourceEnterpriseURL(t *testing.T) {
+	f := PullOnlyFlags{SourceURL: "https://github.com", SourceEnterpriseURL: "https://ghes.example.com", Token: "token", SourceOrgs: []string{"myorg"}}
+	assert.Empty(t, f.Validate())
+
+	f = PullOnlyFlags{SourceURL: "https://github.com", SourceEnterpriseURL: "http://ghes.example.com"}
+	assert.Len(t, f.Validate(), 2)
+
+	f = PullOnlyFlags{SourceURL: "https://github.com", SourceOrgs: []string{"myorg"}}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--source-enterprise-url")
+
+	pull := PullFlags{PullOnlyFlags: PullOnlyFlags{SourceURL: "https://github.com", SourceEnterpriseURL: "https://ghes.example.com", Token: "token", SourceOrgs: []string{"myorg"}}}
+	assert.Empty(t, pull.Validate(), "--source-org stands in for the repository list")
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
@@ -13,8 +14,9 @@ import (
 
 type GitImplementation interface {
 	NewGitRepository(dir string) (GitRepository, error)
-	CloneRepository(dir string, o *git.CloneOptions) (GitRepository, error)
+	CloneRepository(dir string, bare bool, o *git.CloneOptions) (GitRepository, error)
 	RepositoryExists(dir string) bool
+	ConvertToBare(dir string) (bool, error)
 }
 
 type GitRepository interface {
@@ -23,10 +25,18 @@ type GitRepository interface {
 	FetchContext(context.Context, *git.FetchOptions) error
 	References() (storer.ReferenceIter, error)
 	Head() (*plumbing.Reference, error)
+	Remote(string) (GitRemote, error)
+	TagObject(plumbing.Hash) (*object.Tag, error)
+	CommitObject(plumbing.Hash) (*object.Commit, error)
+	SetReference(*plumbing.Reference) error
+	RemoveReference(plumbing.ReferenceName) error
+	Config() (*config.Config, error)
+	SetConfig(*config.Config) error
 }
 
 type GitRemote interface {
 	PushContext(context.Context, *git.PushOptions) error
+	ListContext(context.Context, *git.ListOptions) ([]*plumbing.Reference, error)
 	Config() *config.RemoteConfig
 }
 
@@ -41,8 +51,8 @@ func (i gitImplementation) NewGitRepository(dir string) (GitRepository, error) {
 	return &gitRepository{gitRepo}, nil
 }
 
-func (i gitImplementation) CloneRepository(dir string, o *git.CloneOptions) (GitRepository, error) {
-	gitRepo, err := git.PlainClone(dir, false, o)
+func (i gitImplementation) CloneRepository(dir string, bare bool, o *git.CloneOptions) (GitRepository, error) {
+	gitRepo, err := git.PlainClone(dir, bare, o)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +64,10 @@ func (i gitImplementation) RepositoryExists(dir string) bool {
 	return err == nil
 }
 
+func (i gitImplementation) ConvertToBare(dir string) (bool, error) {
+	return convertToBare(dir)
+}
+
 type gitRepository struct {
 	inner *git.Repository
 }
@@ -77,3 +91,31 @@ func (r *gitRepository) References() (storer.ReferenceIter, error) {
 func (r *gitRepository) Head() (*plumbing.Reference, error) {
 	return r.inner.Head()
 }
+
+func (r *gitRepository) Remote(name string) (GitRemote, error) {
+	return r.inner.Remote(name)
+}
+
+func (r *gitRepository) TagObject(h plumbing.Hash) (*object.Tag, error) {
+	return r.inner.TagObject(h)
+}
+
+func (r *gitRepository) CommitObject(h plumbing.Hash) (*object.Commit, error) {
+	return r.inner.CommitObject(h)
+}
+
+func (r *gitRepository) SetReference(ref *plumbing.Reference) error {
+	return r.inner.Storer.SetReference(ref)
+}
+
+func (r *gitRepository) RemoveReference(name plumbing.ReferenceName) error {
+	return r.inner.Storer.RemoveReference(name)
+}
+
+func (r *gitRepository) Config() (*config.Config, error) {
+	return r.inner.Config()
+}
+
+func (r *gitRepository) SetConfig(cfg *config.Config) error {
+	return r.inner.SetConfig(cfg)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/stretchr/testify/assert"
 )
@@ -73,3 +74,12 @@ func TestMockGitRemote_Config(t *testing.T) {
 	cfg = customRemote.Config()
 	assert.Equal(t, "custom-remote", cfg.Name)
 }
+
+func TestMockGitRemote_ListContext(t *testing.T) {
+	remote := &mockGitRemote{listRefs: []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v1"), plumbing.ZeroHash),
+	}}
+	refs, err := remote.ListContext(context.Background(), &git.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, refs, 1)
+}
@@ -0,0 +1,464 @@
+package src
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// appJWTLifetime is how long each App JWT is valid for. GitHub rejects JWTs
+// that expire more than 10 minutes in the future.
+const appJWTLifetime = 9 * time.Minute
+
+// appTokenRefreshMargin is how long before an installation token expires that
+// a new one is requested, so a token never runs out in the middle of a push.
+const appTokenRefreshMargin = 5 * time.Minute
+
+// GitHubAppFlags identify a GitHub App whose installation tokens are used to
+// authenticate, instead of a static token.
+type GitHubAppFlags struct {
+	AppID, InstallationID int64
+	PrivateKeyFile        string
+}
+
+// IsSet reports whether App authentication was requested.
+func (f *GitHubAppFlags) IsSet() bool {
+	return f.AppID != 0 || f.PrivateKeyFile != "" || f.InstallationID != 0
+}
+
+// Validate checks the App flags, prefix is the flag prefix such as `source`.
+func (f *GitHubAppFlags) Validate(prefix string) Validations {
+	var validations Validations
+	if !f.IsSet() {
+		return validations
+	}
+	if f.AppID <= 0 {
+		validations = append(validations, fmt.Sprintf("--%s-app-id must be set to use GitHub App authentication", prefix))
+	}
+	if f.PrivateKeyFile == "" {
+		validations = append(validations, fmt.Sprintf("--%s-app-private-key-file must be set to use GitHub App authentication", prefix))
+	}
+	if f.InstallationID < 0 {
+		validations = append(validations, fmt.Sprintf("--%s-app-installation-id cannot be negative", prefix))
+	}
+	return validations
+}
+
+// githubApp creates JWTs for a GitHub App and exchanges them for installation
+// tokens against the API at apiURL.
+type githubApp struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *github.Client
+}
+
+func newGitHubApp(flags *GitHubAppFlags, apiURL string, base http.RoundTripper) (*githubApp, error) {
+	pemBytes, err := os.ReadFile(flags.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading GitHub App private key")
+	}
+	key, err := parseAppPrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &githubApp{id: flags.AppID, key: key}
+	httpClient := &http.Client{Transport: &appJWTTransport{app: app, base: base}}
+	app.client, err = newAPIClient(apiURL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func parseAppPrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("GitHub App private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing GitHub App private key")
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// jwt returns a JWT signed with the App's private key. It is backdated a
+// minute to allow for clock drift between us and the server.
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "error signing GitHub App JWT")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// installationID looks up the App's installation on the given user or
+// organization account.
+func (a *githubApp) installationID(ctx context.Context, owner string) (int64, error) {
+	installation, resp, err := a.client.Apps.FindOrganizationInstallation(ctx, owner)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, _, err = a.client.Apps.FindUserInstallation(ctx, owner)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "error finding the GitHub App installation for `%s`", owner)
+	}
+	return installation.GetID(), nil
+}
+
+// TokenSource returns a source of installation tokens for the installation
+// with the given ID, or the installation on owner when the ID is 0.
+func (a *githubApp) TokenSource(installationID int64, owner string) *installationTokenSource {
+	return &installationTokenSource{app: a, installationID: installationID, owner: owner}
+}
+
+// installationTokenSource hands out installation tokens, reusing each until
+// shortly before it expires. New tokens are created with the context of the
+// request they are for, so they are abandoned along with it.
+type installationTokenSource struct {
+	app   *githubApp
+	owner string
+
+	mu             sync.Mutex
+	installationID int64
+	token          *oauth2.Token
+}
+
+var _ requestTokenSource = &installationTokenSource{}
+
+// Token returns a token for callers that have no request, as oauth2 expects.
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	return s.contextToken(context.Background())
+}
+
+func (s *installationTokenSource) requestToken(req *http.Request) (*oauth2.Token, error) {
+	return s.contextToken(req.Context())
+}
+
+// contextToken returns the current token, or creates a new one with ctx.
+func (s *installationTokenSource) contextToken(ctx context.Context) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	if s.installationID == 0 {
+		id, err := s.app.installationID(ctx, s.owner)
+		if err != nil {
+			return nil, err
+		}
+		s.installationID = id
+	}
+
+	token, _, err := s.app.client.Apps.CreateInstallationToken(ctx, s.installationID, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating a token for GitHub App installation %d", s.installationID)
+	}
+	s.token = &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Add(-appTokenRefreshMargin),
+	}
+	return s.token, nil
+}
+
+// appJWTTransport authenticates requests as the App itself, which is what
+// the installation endpoints expect.
+type appJWTTransport struct {
+	app  *githubApp
+	base http.RoundTripper
+}
+
+func (t *appJWTTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := t.app.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return t.base.RoundTrip(req)
+}
+
+// tokenSourceAuth is a git HTTP auth method that asks the token source for a
+// token on every request, so long running pulls and pushes pick up refreshed
+// installation tokens.
+type tokenSourceAuth struct {
+	ts oauth2.TokenSource
+
+	mu sync.Mutex
+	// err is why the last request went out without a token
+	err error
+}
+
+var _ githttp.AuthMethod = &tokenSourceAuth{}
+
+func (a *tokenSourceAuth) SetAuth(r *http.Request) {
+	var token *oauth2.Token
+	var err error
+	if rts, ok := a.ts.(requestTokenSource); ok {
+		token, err = rts.requestToken(r)
+	} else {
+		token, err = a.ts.Token()
+	}
+	a.mu.Lock()
+	a.err = err
+	a.mu.Unlock()
+	if err != nil {
+		// git has no way to fail the request here, so it goes out
+		// unauthenticated and withTokenError reports why it failed
+		return
+	}
+	r.SetBasicAuth("x-access-token", token.AccessToken)
+}
+
+func (a *tokenSourceAuth) Name() string {
+	return "http-token-source-auth"
+}
+
+func (a *tokenSourceAuth) String() string {
+	return fmt.Sprintf("%s - x-access-token:%s", a.Name(), "*******")
+}
+
+// tokenSourceGitAuth returns a git auth method backed by ts.
+func tokenSourceGitAuth(ts oauth2.TokenSource) transport.AuthMethod {
+	return &tokenSourceAuth{ts: ts}
+}
+
+// withTokenError returns err, the error of a git operation authenticated with
+// auth, along with the error of the token source when it had no token for the
+// operation's last request.
+func withTokenError(auth transport.AuthMethod, err error) error {
+	a, ok := auth.(*tokenSourceAuth)
+	if !ok || err == nil {
+		return err
+	}
+	a.mu.Lock()
+	tokenErr := a.err
+	a.mu.Unlock()
+	if tokenErr == nil {
+		return err
+	}
+	return errors.Wrapf(err, "error getting a token: %s", tokenErr)
+}
+
+// newAPIClient returns a REST API client for the instance at baseURL, which is
+// either github.com or a GitHub Enterprise Server instance.
+func newAPIClient(baseURL string, httpClient *http.Client) (*github.Client, error) {
+	if isDotCom(baseURL) {
+		return github.NewClient(httpClient), nil
+	}
+	return github.NewEnterpriseClient(baseURL, baseURL, httpClient)
+}
+
+// isDotCom reports whether baseURL points at github.com rather than a GitHub
+// Enterprise Server instance.
+func isDotCom(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "github.com" || host == "api.github.com"
+}
+
+// appTokenSource returns installation tokens of the App described by flags
+// against the API at apiURL. Without an installation ID the installation of
+// each account owning repositories is looked up the first time it is needed,
+// starting with the owners of repoNames, whose first tokens are requested
+// straight away so configuration problems surface before any work starts. API
+// requests are sent with base.
+func appTokenSource(ctx context.Context, flags *GitHubAppFlags, apiURL string, repoNames []string, dest bool, base http.RoundTripper) (oauth2.TokenSource, error) {
+	app, err := newGitHubApp(flags, apiURL, base)
+	if err != nil {
+		return nil, err
+	}
+	if flags.InstallationID != 0 {
+		ts := app.TokenSource(flags.InstallationID, "")
+		if _, err := ts.contextToken(ctx); err != nil {
+			return nil, err
+		}
+		return ts, nil
+	}
+
+	owners, err := repoOwners(repoNames, dest)
+	if err != nil {
+		return nil, err
+	}
+	ts := &appInstallations{app: app, defaultOwner: owners[0], sources: map[string]*installationTokenSource{}}
+	for _, owner := range owners {
+		if _, err := ts.forOwner(owner).contextToken(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// repoOwners returns the accounts that own the repositories, the destination
+// owners when dest is set and the upstream owners otherwise, in the order they
+// first appear.
+func repoOwners(repoNames []string, dest bool) ([]string, error) {
+	var owners []string
+	seen := map[string]bool{}
+	for _, repoName := range repoNames {
+		spec, err := parseRepoSpec(repoName)
+		if err != nil {
+			return nil, err
+		}
+		nwo := spec.origin
+		if dest {
+			nwo = destinationNwo(spec.dest)
+		}
+		owner, _, err := splitNwo(nwo)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[strings.ToLower(owner)] {
+			seen[strings.ToLower(owner)] = true
+			owners = append(owners, owner)
+		}
+	}
+	if len(owners) == 0 {
+		return nil, errors.New("no repositories to find the GitHub App installation for")
+	}
+	return owners, nil
+}
+
+// requestTokenSource is implemented by token sources whose token depends on
+// what a request is for.
+type requestTokenSource interface {
+	requestToken(req *http.Request) (*oauth2.Token, error)
+}
+
+// appInstallations hands out tokens of an App installed on several accounts.
+// Each request gets a token of the installation on the account owning the
+// repository or organization it is for, which is looked up and cached the
+// first time it is needed.
+type appInstallations struct {
+	app *githubApp
+	// defaultOwner's installation is used for requests that don't name an
+	// owner
+	defaultOwner string
+
+	mu      sync.Mutex
+	sources map[string]*installationTokenSource
+}
+
+// forOwner returns the token source of the installation on owner.
+func (a *appInstallations) forOwner(owner string) *installationTokenSource {
+	if owner == "" {
+		owner = a.defaultOwner
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ts := a.sources[strings.ToLower(owner)]
+	if ts == nil {
+		ts = a.app.TokenSource(0, owner)
+		a.sources[strings.ToLower(owner)] = ts
+	}
+	return ts
+}
+
+func (a *appInstallations) Token() (*oauth2.Token, error) {
+	return a.forOwner("").Token()
+}
+
+func (a *appInstallations) requestToken(req *http.Request) (*oauth2.Token, error) {
+	return a.forOwner(requestOwner(req.URL)).requestToken(req)
+}
+
+// requestOwner returns the account a git or REST API request is for, or an
+// empty string when it doesn't name one. Git requests go to the repository URL
+// followed by the service path, and API requests name the owner after
+// `repos`, `orgs` or `users`, or in the query of a search.
+func requestOwner(u *url.URL) string {
+	p := strings.Trim(u.Path, "/")
+	for _, service := range []string{"/info/refs", "/git-upload-pack", "/git-receive-pack"} {
+		if strings.HasSuffix(p, service) {
+			segments := strings.Split(strings.TrimSuffix(p, service), "/")
+			if len(segments) < 2 {
+				return ""
+			}
+			return segments[len(segments)-2]
+		}
+	}
+
+	segments := strings.Split(p, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		switch segments[i] {
+		case "repos", "orgs", "users":
+			return segments[i+1]
+		}
+	}
+	for _, term := range strings.Fields(u.Query().Get("q")) {
+		for _, qualifier := range []string{"org:", "user:", "repo:"} {
+			if value, ok := strings.CutPrefix(term, qualifier); ok {
+				owner, _, _ := strings.Cut(value, "/")
+				return owner
+			}
+		}
+	}
+	return ""
+}
+
+// requestTokenTransport authenticates each request with the token ts hands
+// out for it.
+type requestTokenTransport struct {
+	source requestTokenSource
+	base   http.RoundTripper
+}
+
+func (t *requestTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.requestToken(req)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	token.SetAuthHeader(req)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,286 @@
+package src
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAppServer is a mock of the GitHub App installation endpoints. It checks
+// every request carries a JWT signed by key and hands out numbered tokens
+// that expire after tokenLifetime.
+type fakeAppServer struct {
+	mu             sync.Mutex
+	key            *rsa.PrivateKey
+	tokenLifetime  time.Duration
+	orgInstalled   bool
+	tokensCreated  int
+	installationID string
+	claims         map[string]int64
+}
+
+func (f *fakeAppServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	claims, err := verifyTestJWT(&f.key.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	f.claims = claims
+
+	switch {
+	case r.URL.Path == "/api/v3/orgs/myorg/installation" && f.orgInstalled:
+		fmt.Fprint(w, `{"id": 42}`)
+	case r.URL.Path == "/api/v3/users/myorg/installation" && !f.orgInstalled:
+		fmt.Fprint(w, `{"id": 43}`)
+	case r.URL.Path == "/api/v3/orgs/otherorg/installation":
+		fmt.Fprint(w, `{"id": 44}`)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/v3/app/installations/"):
+		f.installationID = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v3/app/installations/"), "/access_tokens")
+		f.tokensCreated++
+		expires := time.Now().Add(f.tokenLifetime).UTC().Format(time.RFC3339)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "ghs_%d_%s", "expires_at": %q}`, f.tokensCreated, f.installationID, expires)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func verifyTestJWT(pub *rsa.PublicKey, jwt string) (map[string]int64, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT %q", jwt)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]int64{}
+	return claims, json.Unmarshal(payload, &claims)
+}
+
+// newTestAppFlags writes a new private key to disk and returns App flags using
+// it, along with the key.
+func newTestAppFlags(t *testing.T, pkcs8 bool) (*GitHubAppFlags, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	keyFile := path.Join(t.TempDir(), "app.pem")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	return &GitHubAppFlags{AppID: 1234, PrivateKeyFile: keyFile}, key
+}
+
+func TestAppTokenSource_LooksUpInstallationByOrg(t *testing.T) {
+	flags, key := newTestAppFlags(t, false)
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Hour, orgInstalled: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := appTokenSource(context.Background(), flags, server.URL, []string{"myorg/a", "MyOrg/b"}, false, http.DefaultTransport)
+	require.NoError(t, err)
+	token, err := ts.Token()
+	require.NoError(t, err)
+
+	assert.Equal(t, "ghs_1_42", token.AccessToken)
+	assert.Equal(t, 1, fake.tokensCreated, "the token should be reused until it expires")
+	assert.Equal(t, "42", fake.installationID)
+	assert.Equal(t, int64(1234), fake.claims["iss"])
+	assert.LessOrEqual(t, fake.claims["exp"]-fake.claims["iat"], int64(10*60), "GitHub rejects JWTs valid for more than 10 minutes")
+}
+
+func TestAppTokenSource_UserInstallationAndPKCS8Key(t *testing.T) {
+	flags, key := newTestAppFlags(t, true)
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Hour}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	_, err := appTokenSource(context.Background(), flags, server.URL, []string{"myorg/a"}, false, http.DefaultTransport)
+	require.NoError(t, err)
+	assert.Equal(t, "43", fake.installationID)
+}
+
+func TestAppTokenSource_ExplicitInstallationID(t *testing.T) {
+	flags, key := newTestAppFlags(t, false)
+	flags.InstallationID = 7
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Hour}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// repositories of several owners are fine with an explicit installation
+	_, err := appTokenSource(context.Background(), flags, server.URL, []string{"a/a", "b/b"}, true, http.DefaultTransport)
+	require.NoError(t, err)
+	assert.Equal(t, "7", fake.installationID)
+}
+
+func TestAppTokenSource_RefreshesExpiringTokens(t *testing.T) {
+	flags, key := newTestAppFlags(t, false)
+	flags.InstallationID = 7
+	// tokens expiring within the refresh margin are replaced on next use
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Minute}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := appTokenSource(context.Background(), flags, server.URL, nil, true, http.DefaultTransport)
+	require.NoError(t, err)
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_2_7", token.AccessToken)
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+	tokenSourceGitAuth(ts).(*tokenSourceAuth).SetAuth(req)
+	_, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "ghs_3_7", password, "git requests should use a fresh token too")
+}
+
+func TestAppTokenSource_UsesRequestContext(t *testing.T) {
+	flags, key := newTestAppFlags(t, false)
+	flags.InstallationID = 7
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Minute}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := appTokenSource(ctx, flags, server.URL, nil, true, http.DefaultTransport)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, fake.tokensCreated)
+
+	ts, err := appTokenSource(context.Background(), flags, server.URL, nil, true, http.DefaultTransport)
+	require.NoError(t, err)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+	auth := tokenSourceGitAuth(ts)
+	auth.(*tokenSourceAuth).SetAuth(req)
+	_, _, ok := req.BasicAuth()
+	assert.False(t, ok, "no token is created for a cancelled request")
+	assert.Equal(t, 1, fake.tokensCreated)
+
+	err = withTokenError(auth, transport.ErrAuthenticationRequired)
+	assert.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+	assert.ErrorContains(t, err, "error getting a token")
+	assert.ErrorContains(t, err, "context canceled")
+}
+
+func TestAppTokenSource_InstallationPerOwner(t *testing.T) {
+	flags, key := newTestAppFlags(t, false)
+	fake := &fakeAppServer{key: key, tokenLifetime: time.Hour, orgInstalled: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := appTokenSource(context.Background(), flags, server.URL, []string{"actions/checkout:myorg/checkout", "actions/cache:otherorg/cache"}, true, http.DefaultTransport)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.tokensCreated, "the installation of every owner is looked up straight away")
+
+	auth := tokenSourceGitAuth(ts).(*tokenSourceAuth)
+	for url, want := range map[string]string{
+		"https://ghes.example.com/otherorg/cache.git/info/refs?service=git-receive-pack": "ghs_2_44",
+		"https://ghes.example.com/myorg/checkout/git-receive-pack":                       "ghs_1_42",
+	} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		auth.SetAuth(req)
+		_, password, ok := req.BasicAuth()
+		require.True(t, ok)
+		assert.Equal(t, want, password, url)
+	}
+
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+	client := newTokenHTTPClient(ts, nil)
+	for _, p := range []string{"/api/v3/repos/otherorg/cache", "/api/v3/orgs/myorg/repos", "/api/v3/user"} {
+		resp, err := client.Get(api.URL + p)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"token ghs_2_44", "token ghs_1_42", "token ghs_1_42"}, seen, "requests that name no owner use the first one")
+	assert.Equal(t, 2, fake.tokensCreated, "tokens are reused per owner")
+}
+
+func TestRequestOwner(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://github.com/actions/checkout.git/info/refs?service=git-upload-pack":        "actions",
+		"https://ghes.example.com/prefix/myorg/checkout/git-upload-pack":                   "myorg",
+		"https://ghes.example.com/api/v3/repos/myorg/checkout/git/refs":                    "myorg",
+		"https://ghes.example.com/api/v3/orgs/myorg/repos":                                 "myorg",
+		"https://api.github.com/users/monalisa/repos":                                      "monalisa",
+		"https://api.github.com/search/repositories?q=topic:github-action+org:aws-actions": "aws-actions",
+		"https://api.github.com/user":                                                      "",
+	} {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		assert.Equal(t, want, requestOwner(u), raw)
+	}
+}
+
+func TestRepoOwners(t *testing.T) {
+	owners, err := repoOwners([]string{"actions/checkout:myorg/checkout", "actions/cache:MyOrg/cache"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myorg"}, owners)
+
+	owners, err = repoOwners([]string{"actions/checkout", "github/codeql-action", "actions/cache"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions", "github"}, owners)
+
+	_, err = repoOwners(nil, false)
+	assert.Error(t, err)
+}
+
+func TestGitHubAppFlags_Validate(t *testing.T) {
+	pull := &PullOnlyFlags{SourceURL: "https://github.com", SourceApp: GitHubAppFlags{AppID: 1}}
+	validations := pull.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--source-app-private-key-file")
+
+	push := PushOnlyFlags{BaseURL: "https://ghes.example.com", DestinationApp: GitHubAppFlags{AppID: 1, PrivateKeyFile: "app.pem"}}
+	assert.Empty(t, push.Validate(), "no destination token is needed with an App")
+
+	push.Token = "token"
+	push.ActionsAdminUser = "actions-admin"
+	validations = push.Validate()
+	require.Len(t, validations, 2)
+	assert.Contains(t, validations[0], "--destination-token")
+	assert.Contains(t, validations[1], "--actions-admin-user")
+}
@@ -0,0 +1,327 @@
+package src
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+)
+
+// IntegrityManifestFile is the file in the root of the cache directory where
+// pull records what it cached, so push can check the cache arrived intact. Its
+// name starts with a dot so it isn't taken for an owner directory.
+const IntegrityManifestFile = ".actions-sync-integrity.json"
+
+// integrityManifest records every cached repository as pull left it.
+type integrityManifest struct {
+	Version int `json:"version"`
+	// SourceEnterpriseURL is set once the cache has been pulled from a GHES
+	// source, whose repositories may be private
+	SourceEnterpriseURL string                    `json:"source-enterprise-url,omitempty"`
+	Repositories        map[string]*repoIntegrity `json:"repositories"`
+}
+
+// repoIntegrity is the state of a cached repository: the hash every ref
+// points at and a checksum of its object store.
+type repoIntegrity struct {
+	Refs    map[string]string `json:"refs"`
+	Objects string            `json:"objects"`
+}
+
+// readIntegrityManifest reads the integrity manifest of the cache, returning
+// nil when there isn't one.
+func readIntegrityManifest(cacheDir string) (*integrityManifest, error) {
+	file := path.Join(cacheDir, IntegrityManifestFile)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading integrity manifest `%s`", file)
+	}
+	m := &integrityManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "error parsing integrity manifest `%s`", file)
+	}
+	if m.Repositories == nil {
+		m.Repositories = map[string]*repoIntegrity{}
+	}
+	return m, nil
+}
+
+// write replaces the integrity manifest of the cache and returns its digest.
+// The new manifest is written next to it first, so an interrupted pull can't
+// leave half of one.
+func (m *integrityManifest) write(cacheDir string) (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	data = append(data, '\n')
+	file := path.Join(cacheDir, IntegrityManifestFile)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", errors.Wrapf(err, "error writing integrity manifest `%s`", tmp)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return "", errors.Wrapf(err, "error writing integrity manifest `%s`", file)
+	}
+	return digest(data), nil
+}
+
+// digest returns the SHA-256 of data in the form printed for
+// --integrity-digest.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checkDigest compares the digest of what was read with the one passed to
+// --integrity-digest, which is carried to the push host separately from the
+// cache so that a manifest rewritten along with the cache is caught. A
+// mismatch is an error unless --ignore-integrity-mismatch is set.
+func checkDigest(flags *PushFlags, what, actual string, out io.Writer) error {
+	if flags.IntegrityDigest == "" {
+		fmt.Fprintf(out, "WARNING: --integrity-digest is not set, so the %s itself can't be verified\n", what)
+		return nil
+	}
+	if actual == flags.IntegrityDigest {
+		fmt.Fprintf(out, "the %s matches --integrity-digest\n", what)
+		return nil
+	}
+	fmt.Fprintf(out, "integrity mismatch: the %s has digest %s instead of %s\n", what, actual, flags.IntegrityDigest)
+	if flags.IgnoreIntegrityMismatch {
+		fmt.Fprintln(out, "WARNING: pushing anyway because --ignore-integrity-mismatch is set")
+		return nil
+	}
+	return errors.Errorf("the %s does not match --integrity-digest, refusing to push. Pass --ignore-integrity-mismatch to push anyway", what)
+}
+
+// recordCacheIntegrity updates the integrity manifest of the cache after a
+// pull. The repositories in repoNames are recorded afresh, along with any that
+// the manifest doesn't know yet, and repositories no longer in the cache are
+// dropped. A pull from a GHES source marks the cache as holding its
+// repositories. It returns the digest of the new manifest.
+func recordCacheIntegrity(cacheDir string, repoNames []string, sourceEnterpriseURL string) (string, error) {
+	m, err := readIntegrityManifest(cacheDir)
+	if err != nil {
+		return "", err
+	}
+	if m == nil {
+		m = &integrityManifest{Repositories: map[string]*repoIntegrity{}}
+	}
+	m.Version = 1
+	if sourceEnterpriseURL != "" {
+		m.SourceEnterpriseURL = sourceEnterpriseURL
+	}
+
+	pulled := map[string]bool{}
+	for _, repoName := range repoNames {
+		if spec, err := parseRepoSpec(repoName); err == nil {
+			pulled[spec.dest] = true
+		}
+	}
+	cached, err := getRepoNamesFromCacheDir(&CommonFlags{CacheDir: cacheDir})
+	if err != nil && err != ErrEmptyCacheDir {
+		return "", err
+	}
+
+	repos := map[string]*repoIntegrity{}
+	for _, nwo := range cached {
+		if r, ok := m.Repositories[nwo]; ok && !pulled[nwo] {
+			repos[nwo] = r
+			continue
+		}
+		r, err := computeRepoIntegrity(path.Join(cacheDir, nwo))
+		if err != nil {
+			return "", errors.Wrapf(err, "error recording the integrity of `%s`", nwo)
+		}
+		repos[nwo] = r
+	}
+	m.Repositories = repos
+	return m.write(cacheDir)
+}
+
+// verify compares the repository nwo in the cache with the manifest and
+// returns a description of each difference.
+func (m *integrityManifest) verify(cacheDir, nwo string) ([]string, error) {
+	recorded, ok := m.Repositories[nwo]
+	if !ok {
+		return []string{fmt.Sprintf("`%s` is not in the integrity manifest", nwo)}, nil
+	}
+	current, err := computeRepoIntegrity(path.Join(cacheDir, nwo))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking the integrity of `%s`", nwo)
+	}
+
+	var problems []string
+	for _, name := range sortedKeys(recorded.Refs) {
+		hash, ok := current.Refs[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("`%s` is missing %s", nwo, name))
+		case hash != recorded.Refs[name]:
+			problems = append(problems, fmt.Sprintf("%s of `%s` points at %s instead of %s", name, nwo, hash, recorded.Refs[name]))
+		}
+	}
+	for _, name := range sortedKeys(current.Refs) {
+		if _, ok := recorded.Refs[name]; !ok {
+			problems = append(problems, fmt.Sprintf("`%s` has an unrecorded ref %s", nwo, name))
+		}
+	}
+	if current.Objects != recorded.Objects {
+		problems = append(problems, fmt.Sprintf("the object store of `%s` has changed", nwo))
+	}
+	return problems, nil
+}
+
+// checkCacheIntegrity verifies the repositories about to be pushed against the
+// integrity manifest written by pull, and the manifest against
+// --integrity-digest, and refuses to push when they don't match unless
+// --ignore-integrity-mismatch is set. A cache without a manifest, such as one
+// pulled by an older version, is pushed with a warning, unless
+// --integrity-digest is set: the manifest it names must then be there, as
+// deleting it must not skip the check.
+func checkCacheIntegrity(flags *PushFlags, repoNames []string, out io.Writer) error {
+	m, err := readIntegrityManifest(flags.CacheDir)
+	if err != nil {
+		return err
+	}
+	if m == nil && flags.IntegrityDigest == "" {
+		fmt.Fprintf(out, "WARNING: the cache directory has no %s, so its contents can't be verified\n", IntegrityManifestFile)
+		return nil
+	}
+	if m == nil {
+		fmt.Fprintf(out, "integrity mismatch: the cache directory has no %s but --integrity-digest is set\n", IntegrityManifestFile)
+		if flags.IgnoreIntegrityMismatch {
+			fmt.Fprintln(out, "WARNING: pushing anyway because --ignore-integrity-mismatch is set")
+			return nil
+		}
+		return errors.Errorf("the cache directory has no %s to check against --integrity-digest, refusing to push. Pass --ignore-integrity-mismatch to push anyway", IntegrityManifestFile)
+	}
+	data, err := os.ReadFile(path.Join(flags.CacheDir, IntegrityManifestFile))
+	if err != nil {
+		return errors.Wrapf(err, "error reading integrity manifest `%s`", IntegrityManifestFile)
+	}
+	if err := checkDigest(flags, "integrity manifest", digest(data), out); err != nil {
+		return err
+	}
+
+	var problems []string
+	verified := 0
+	checked := map[string]bool{}
+	for _, repoName := range repoNames {
+		spec, err := parseRepoSpec(repoName)
+		if err != nil || checked[spec.dest] {
+			continue
+		}
+		checked[spec.dest] = true
+		// repositories missing from the cache fail to push on their own
+		if _, err := os.Stat(path.Join(flags.CacheDir, spec.dest)); err != nil {
+			continue
+		}
+		found, err := m.verify(flags.CacheDir, spec.dest)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, found...)
+		verified++
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "verified %d repositories against the integrity manifest\n", verified)
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(out, "integrity mismatch: %s\n", problem)
+	}
+	if flags.IgnoreIntegrityMismatch {
+		fmt.Fprintln(out, "WARNING: pushing anyway because --ignore-integrity-mismatch is set")
+		return nil
+	}
+	return errors.Errorf("the cache does not match its integrity manifest (%d problems), refusing to push. Pass --ignore-integrity-mismatch to push anyway", len(problems))
+}
+
+// computeRepoIntegrity reads the refs and checksums the object store of the
+// repository cached at dir.
+func computeRepoIntegrity(dir string) (*repoIntegrity, error) {
+	repo, err := gitImplementation{}.NewGitRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := hashReferences(repo)
+	if err != nil {
+		return nil, err
+	}
+	r := &repoIntegrity{Refs: map[string]string{}}
+	for _, ref := range refs {
+		r.Refs[ref.Name().String()] = ref.Hash().String()
+	}
+	r.Objects, err = checksumObjects(dir)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checksumObjects returns a SHA-256 over the name and content of every file in
+// the object store of the repository at dir, which is inside `.git` for
+// repositories with a working tree.
+func checksumObjects(dir string) (string, error) {
+	objectsDir := path.Join(dir, "objects")
+	if info, err := os.Stat(path.Join(dir, git.GitDirName)); err == nil && info.IsDir() {
+		objectsDir = path.Join(dir, git.GitDirName, "objects")
+	}
+
+	var files []string
+	err := filepath.WalkDir(objectsDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			rel, err := filepath.Rel(objectsDir, filePath)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading the object store of `%s`", dir)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, name := range files {
+		fmt.Fprintf(h, "%s\x00", name)
+		f, err := os.Open(filepath.Join(objectsDir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", errors.Wrapf(err, "error reading the object store of `%s`", dir)
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", errors.Wrapf(err, "error reading the object store of `%s`", dir)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
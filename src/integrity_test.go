@@ -0,0 +1,184 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordCacheIntegrity(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := initTestRepository(t, path.Join(cacheDir, "actions", "checkout"))
+	initTestRepository(t, path.Join(cacheDir, "actions", "setup-go"))
+
+	recordTestIntegrity(t, cacheDir, []string{"actions/checkout"}, "")
+	assert.FileExists(t, path.Join(cacheDir, IntegrityManifestFile))
+	repoNames, err := getRepoNamesFromCacheDir(&CommonFlags{CacheDir: cacheDir})
+	require.NoError(t, err, "the manifest is not taken for an owner")
+	assert.ElementsMatch(t, []string{"actions/checkout", "actions/setup-go"}, repoNames)
+
+	m, err := readIntegrityManifest(cacheDir)
+	require.NoError(t, err)
+	require.Contains(t, m.Repositories, "actions/setup-go", "repositories the manifest doesn't know are recorded too")
+	checkout := m.Repositories["actions/checkout"]
+	require.NotNil(t, checkout)
+	assert.Equal(t, hash.String(), checkout.Refs["refs/heads/main"])
+	assert.Equal(t, hash.String(), checkout.Refs["refs/tags/v1.0.0"])
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, checkout.Objects)
+
+	problems, err := m.verify(cacheDir, "actions/checkout")
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// a change made after the pull is noticed, and isn't recorded by a pull
+	// of other repositories
+	commitTestFile(t, path.Join(cacheDir, "actions", "setup-go"), "name: changed\n", time.Now())
+	recordTestIntegrity(t, cacheDir, []string{"actions/checkout"}, "")
+	m, err = readIntegrityManifest(cacheDir)
+	require.NoError(t, err)
+	problems, err = m.verify(cacheDir, "actions/setup-go")
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	assert.Contains(t, problems[0], "refs/heads/main of `actions/setup-go` points at")
+	assert.Contains(t, problems[1], "object store of `actions/setup-go` has changed")
+
+	recordTestIntegrity(t, cacheDir, []string{"actions/setup-go"}, "")
+	m, err = readIntegrityManifest(cacheDir)
+	require.NoError(t, err)
+	problems, err = m.verify(cacheDir, "actions/setup-go")
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestIntegrityManifest_VerifyRefs(t *testing.T) {
+	cacheDir := t.TempDir()
+	dir := path.Join(cacheDir, "actions", "checkout")
+	hash := initTestRepository(t, dir)
+	recordTestIntegrity(t, cacheDir, nil, "")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteTag("v1.0.0"))
+	_, err = repo.CreateTag("v2.0.0", hash, nil)
+	require.NoError(t, err)
+
+	m, err := readIntegrityManifest(cacheDir)
+	require.NoError(t, err)
+	problems, err := m.verify(cacheDir, "actions/checkout")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"`actions/checkout` is missing refs/tags/v1.0.0",
+		"`actions/checkout` has an unrecorded ref refs/tags/v2.0.0",
+	}, problems)
+
+	problems, err = m.verify(cacheDir, "actions/unknown")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"`actions/unknown` is not in the integrity manifest"}, problems)
+}
+
+func TestCheckCacheIntegrity(t *testing.T) {
+	cacheDir := t.TempDir()
+	dir := path.Join(cacheDir, "actions", "checkout")
+	initTestRepository(t, dir)
+	flags := &PushFlags{CommonFlags: CommonFlags{CacheDir: cacheDir}}
+
+	var out bytes.Buffer
+	manifestDigest := recordTestIntegrity(t, cacheDir, []string{"actions/checkout"}, "")
+	out.Reset()
+	require.NoError(t, checkCacheIntegrity(flags, []string{"actions/checkout@v1.0.0", "actions/missing"}, &out))
+	assert.Contains(t, out.String(), "verified 1 repositories")
+	assert.Contains(t, out.String(), "--integrity-digest is not set")
+
+	flags.IntegrityDigest = manifestDigest
+	out.Reset()
+	require.NoError(t, checkCacheIntegrity(flags, []string{"actions/checkout"}, &out))
+	assert.Contains(t, out.String(), "matches --integrity-digest")
+
+	// a manifest regenerated after tampering no longer matches the digest
+	commitTestFile(t, dir, "name: tampered\n", time.Now())
+	recordTestIntegrity(t, cacheDir, []string{"actions/checkout"}, "")
+	out.Reset()
+	err := checkCacheIntegrity(flags, []string{"actions/checkout"}, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match --integrity-digest")
+	flags.IntegrityDigest = ""
+
+	commitTestFile(t, dir, "name: tampered again\n", time.Now())
+	out.Reset()
+	err = checkCacheIntegrity(flags, []string{"actions/checkout"}, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to push")
+	assert.Contains(t, out.String(), "integrity mismatch: ")
+
+	flags.IgnoreIntegrityMismatch = true
+	out.Reset()
+	require.NoError(t, checkCacheIntegrity(flags, []string{"actions/checkout"}, &out))
+	assert.Contains(t, out.String(), "pushing anyway")
+}
+
+func TestCheckCacheIntegrity_NoManifest(t *testing.T) {
+	cacheDir := t.TempDir()
+	initTestRepository(t, path.Join(cacheDir, "actions", "checkout"))
+	flags := &PushFlags{CommonFlags: CommonFlags{CacheDir: cacheDir}}
+
+	// a cache pulled by an older version has no manifest to check
+	var out bytes.Buffer
+	require.NoError(t, checkCacheIntegrity(flags, []string{"actions/checkout"}, &out))
+	assert.Contains(t, out.String(), "WARNING: the cache directory has no "+IntegrityManifestFile)
+
+	// with a digest, deleting the manifest doesn't skip the check
+	flags.IntegrityDigest = "sha256:0000"
+	out.Reset()
+	err := checkCacheIntegrity(flags, []string{"actions/checkout"}, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to push")
+
+	flags.IgnoreIntegrityMismatch = true
+	out.Reset()
+	require.NoError(t, checkCacheIntegrity(flags, []string{"actions/checkout"}, &out))
+	assert.Contains(t, out.String(), "pushing anyway")
+}
+
+func TestChecksumObjects_BothLayouts(t *testing.T) {
+	dir := path.Join(t.TempDir(), "actions", "checkout")
+	initTestRepository(t, dir)
+	worktree, err := checksumObjects(dir)
+	require.NoError(t, err)
+
+	_, err = convertToBare(dir)
+	require.NoError(t, err)
+	bare, err := checksumObjects(dir)
+	require.NoError(t, err)
+	assert.Equal(t, worktree, bare, "converting the cache to bare doesn't change its objects")
+
+	require.NoError(t, os.WriteFile(path.Join(dir, "objects", "extra"), []byte("x"), 0o644))
+	changed, err := checksumObjects(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, bare, changed)
+}
+
+func TestRecordCacheIntegrity_SourceEnterpriseURL(t *testing.T) {
+	cacheDir := t.TempDir()
+	initTestRepository(t, path.Join(cacheDir, "myorg", "action"))
+	recordTestIntegrity(t, cacheDir, nil, "https://ghes.example.com")
+	recordTestIntegrity(t, cacheDir, nil, "")
+
+	m, err := readIntegrityManifest(cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, "https://ghes.example.com", m.SourceEnterpriseURL, "a later pull from another source doesn't unmark the cache")
+}
+
+// recordTestIntegrity records the integrity manifest of the cache and returns
+// its digest.
+func recordTestIntegrity(t *testing.T, cacheDir string, repoNames []string, sourceEnterpriseURL string) string {
+	t.Helper()
+	manifestDigest, err := recordCacheIntegrity(cacheDir, repoNames, sourceEnterpriseURL)
+	require.NoError(t, err)
+	return manifestDigest
+}
@@ -0,0 +1,349 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v3"
+)
+
+// manifest is a repo list file in YAML or JSON, where each repository can have
+// its own options. Options an entry leaves out fall back to the flags.
+type manifest struct {
+	TokenProfiles map[string]*tokenProfile `yaml:"token-profiles"`
+	Repositories  []*manifestEntry         `yaml:"repositories"`
+
+	// entries maps the repo list entry of each repository to its options
+	entries map[string]*manifestEntry
+}
+
+// manifestEntry is a repository in a manifest and the options that override
+// the flags for it.
+type manifestEntry struct {
+	Repo              string   `yaml:"repo"`
+	Destination       string   `yaml:"destination"`
+	Refs              []string `yaml:"refs"`
+	SourceURL         string   `yaml:"source-url"`
+	IncludeRefs       []string `yaml:"include-refs"`
+	ExcludeRefs       []string `yaml:"exclude-refs"`
+	TagsSemver        string   `yaml:"tags-semver"`
+	DefaultBranchOnly *bool    `yaml:"default-branch-only"`
+	BatchSize         *int     `yaml:"batch-size"`
+	Visibility        string   `yaml:"visibility"`
+	TokenProfile      string   `yaml:"token-profile"`
+
+	profile *tokenProfile
+}
+
+// tokenProfile names where the tokens for some of the repositories in a
+// manifest come from. Tokens themselves can't be written into the manifest, so
+// it can be committed alongside the workflows.
+type tokenProfile struct {
+	SourceTokenEnv          string `yaml:"source-token-env"`
+	SourceTokenFile         string `yaml:"source-token-file"`
+	SourceTokenCommand      string `yaml:"source-token-command"`
+	DestinationTokenEnv     string `yaml:"destination-token-env"`
+	DestinationTokenFile    string `yaml:"destination-token-file"`
+	DestinationTokenCommand string `yaml:"destination-token-command"`
+
+	name string
+	// the token sources and the destination client are set up the first time
+	// they are needed, so token commands run once per profile
+	mu                  sync.Mutex
+	source, destination oauth2.TokenSource
+	client              *github.Client
+}
+
+// isManifestFile reports whether a --repo-name-list-file is a manifest rather
+// than a plain list of names, going by its extension.
+func isManifestFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml", ".json":
+		return true
+	}
+	return false
+}
+
+// loadManifest reads and checks the manifest in file. JSON is read by the YAML
+// parser, and unknown keys are rejected so typos don't go unnoticed.
+func loadManifest(file string) (*manifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(m); err != nil {
+		return nil, errors.Wrapf(err, "error parsing manifest `%s`", file)
+	}
+	if len(m.Repositories) == 0 {
+		return nil, ErrEmptyRepoList
+	}
+
+	for name, profile := range m.TokenProfiles {
+		if profile == nil {
+			return nil, errors.Errorf("token profile `%s` in `%s` is empty", name, file)
+		}
+		profile.name = name
+		if err := profile.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid manifest `%s`", file)
+		}
+	}
+
+	m.entries = map[string]*manifestEntry{}
+	for i, entry := range m.Repositories {
+		if entry == nil || strings.TrimSpace(entry.Repo) == "" {
+			return nil, errors.Errorf("repository %d in manifest `%s` has no `repo`", i+1, file)
+		}
+		if err := entry.validate(m.TokenProfiles); err != nil {
+			return nil, errors.Wrapf(err, "invalid manifest `%s`", file)
+		}
+		repoName := entry.repoName()
+		if _, ok := m.entries[repoName]; ok {
+			return nil, errors.Errorf("`%s` is listed more than once in manifest `%s`", entry.Repo, file)
+		}
+		m.entries[repoName] = entry
+	}
+	return m, nil
+}
+
+// repoNames returns the repo list entry of every repository, in order.
+func (m *manifest) repoNames() []string {
+	repoNames := make([]string, 0, len(m.Repositories))
+	for _, entry := range m.Repositories {
+		repoNames = append(repoNames, entry.repoName())
+	}
+	return repoNames
+}
+
+// entry returns the options for a repo list entry, or nil when there is no
+// manifest or it doesn't list the entry, as with dependencies and entries
+// expanded from --source-org.
+func (m *manifest) entry(repoName string) *manifestEntry {
+	if m == nil {
+		return nil
+	}
+	return m.entries[repoName]
+}
+
+// repoName returns the entry in the format of the plain repo list, such as
+// `actions/checkout@v4:myorg/checkout`.
+func (e *manifestEntry) repoName() string {
+	repoName := strings.TrimSpace(e.Repo)
+	if len(e.Refs) > 0 {
+		repoName += "@" + strings.Join(e.Refs, ",")
+	}
+	if e.Destination == "" {
+		return repoName
+	}
+	if strings.Contains(e.Repo, "://") || isSSHURL(e.Repo) {
+		return repoName + "=>" + e.Destination
+	}
+	return repoName + ":" + e.Destination
+}
+
+func (e *manifestEntry) validate(profiles map[string]*tokenProfile) error {
+	if isRepoSearch(e.Repo) || isRepoWildcard(e.Repo) {
+		return errors.Errorf("`%s`: wildcard and search entries cannot be used in a manifest", e.Repo)
+	}
+	if _, err := parseRepoSpec(e.repoName()); err != nil {
+		return err
+	}
+	if _, err := newRefSelection(&CommonFlags{IncludeRefs: e.IncludeRefs, ExcludeRefs: e.ExcludeRefs, TagsSemver: e.TagsSemver}); err != nil {
+		return errors.Wrapf(err, "`%s`", e.Repo)
+	}
+	switch e.Visibility {
+	case "", "public", "private", "internal":
+	default:
+		return errors.Errorf("`%s`: visibility must be `public`, `private` or `internal`", e.Repo)
+	}
+	if e.BatchSize != nil && *e.BatchSize != 0 && *e.BatchSize < MinBatchSize {
+		return errors.Errorf("`%s`: batch-size must be 0 (no batching) or at least %d", e.Repo, MinBatchSize)
+	}
+	if e.TokenProfile != "" {
+		e.profile = profiles[e.TokenProfile]
+		if e.profile == nil {
+			return errors.Errorf("`%s`: token profile `%s` is not defined", e.Repo, e.TokenProfile)
+		}
+	}
+	if e.profile.hasSourceToken() && e.SourceURL != "" && !strings.HasPrefix(strings.ToLower(e.SourceURL), "https://") {
+		return errors.Errorf("`%s`: a source token requires an https:// source-url so it is sent over a secure transport", e.Repo)
+	}
+	return nil
+}
+
+// applyCommon overrides the ref filters in flags with the entry's.
+func (e *manifestEntry) applyCommon(flags *CommonFlags) {
+	if e.IncludeRefs != nil {
+		flags.IncludeRefs = e.IncludeRefs
+	}
+	if e.ExcludeRefs != nil {
+		flags.ExcludeRefs = e.ExcludeRefs
+	}
+	if e.TagsSemver != "" {
+		flags.TagsSemver = e.TagsSemver
+	}
+}
+
+// pullFlags returns a copy of flags with the entry's options applied, and the
+// auth to pull the entry with. Credentials for --source-url aren't sent to a
+// different source-url, except SSH keys, which can be offered to any host.
+func (e *manifestEntry) pullFlags(flags *PullFlags, auth transport.AuthMethod) (*PullFlags, transport.AuthMethod, error) {
+	entryFlags := *flags
+	e.applyCommon(&entryFlags.CommonFlags)
+	if e.DefaultBranchOnly != nil {
+		entryFlags.DefaultBranchOnly = *e.DefaultBranchOnly
+	}
+	if e.SourceURL != "" && !strings.EqualFold(strings.TrimSuffix(e.SourceURL, "/"), strings.TrimSuffix(flags.SourceURL, "/")) {
+		entryFlags.SourceURL = e.SourceURL
+		// the API client only knows the visibility of --source-enterprise-url
+		// repositories
+		entryFlags.sourceClient = nil
+		if !isSSHURL(e.SourceURL) || !isSSHURL(flags.SourceURL) {
+			auth = nil
+		}
+	}
+	if e.profile.hasSourceToken() {
+		if !strings.HasPrefix(strings.ToLower(entryFlags.SourceURL), "https://") {
+			return nil, nil, errors.Errorf("token profile `%s` requires an https:// source URL for `%s`", e.profile.name, e.Repo)
+		}
+		ts, err := e.profile.sourceTokenSource()
+		if err != nil {
+			return nil, nil, err
+		}
+		// the token is asked for on every request, so a token command can be
+		// run again when the source rejects it
+		entryFlags.tokenSource = ts
+		auth = tokenSourceGitAuth(ts)
+	}
+	return &entryFlags, auth, nil
+}
+
+// pushFlags returns a copy of flags with the entry's options applied, and the
+// client to push the entry with.
+func (e *manifestEntry) pushFlags(flags *PushFlags, ghClient *github.Client) (*PushFlags, *github.Client, error) {
+	entryFlags := *flags
+	e.applyCommon(&entryFlags.CommonFlags)
+	if e.BatchSize != nil {
+		entryFlags.BatchSize = *e.BatchSize
+	}
+	entryFlags.visibility = e.Visibility
+	if e.profile.hasDestinationToken() {
+		ts, err := e.profile.destinationTokenSource()
+		if err != nil {
+			return nil, nil, err
+		}
+		// the profile's token is used like --destination-token, asked for on
+		// every request so a token command can be run again when rejected
+		entryFlags.Token = ""
+		entryFlags.tokenSource = ts
+		if flags.DestinationApp.IsSet() {
+			entryFlags.GitHubApp = false
+		}
+		ghClient, err = e.profile.destinationClient(flags)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return &entryFlags, ghClient, nil
+}
+
+func (p *tokenProfile) validate() error {
+	for _, tokens := range []tokenOptions{p.sourceTokens(), p.destinationTokens()} {
+		n := 0
+		for _, v := range []string{tokens.env, tokens.file, tokens.command} {
+			if v != "" {
+				n++
+			}
+		}
+		if n > 1 {
+			return errors.Errorf("token profile `%s` can only set one of %[2]s-token-env, %[2]s-token-file and %[2]s-token-command", p.name, tokens.prefix)
+		}
+	}
+	return nil
+}
+
+func (p *tokenProfile) sourceTokens() tokenOptions {
+	return tokenOptions{prefix: "source", file: p.SourceTokenFile, command: p.SourceTokenCommand, env: p.SourceTokenEnv}
+}
+
+func (p *tokenProfile) destinationTokens() tokenOptions {
+	return tokenOptions{prefix: "destination", file: p.DestinationTokenFile, command: p.DestinationTokenCommand, env: p.DestinationTokenEnv}
+}
+
+// hasSourceToken reports whether the profile, which may be nil, supplies a
+// source token.
+func (p *tokenProfile) hasSourceToken() bool {
+	return p != nil && (p.SourceTokenEnv != "" || p.SourceTokenFile != "" || p.SourceTokenCommand != "")
+}
+
+// hasDestinationToken reports whether the profile, which may be nil, supplies
+// a destination token.
+func (p *tokenProfile) hasDestinationToken() bool {
+	return p != nil && (p.DestinationTokenEnv != "" || p.DestinationTokenFile != "" || p.DestinationTokenCommand != "")
+}
+
+func (p *tokenProfile) sourceTokenSource() (oauth2.TokenSource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.source == nil {
+		ts, err := p.tokenSource(p.sourceTokens())
+		if err != nil {
+			return nil, err
+		}
+		p.source = ts
+	}
+	return p.source, nil
+}
+
+func (p *tokenProfile) destinationTokenSource() (oauth2.TokenSource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.destination == nil {
+		ts, err := p.tokenSource(p.destinationTokens())
+		if err != nil {
+			return nil, err
+		}
+		p.destination = ts
+	}
+	return p.destination, nil
+}
+
+// destinationClient returns the API client for the profile's destination
+// token. It is created once per profile, so organizations that were already
+// ensured through it aren't looked up again for every repository.
+func (p *tokenProfile) destinationClient(flags *PushFlags) (*github.Client, error) {
+	ts, err := p.destinationTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		p.client, err = github.NewEnterpriseClient(flags.BaseURL, flags.BaseURL, newTokenHTTPClient(ts, flags.destination.roundTripper()))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating enterprise client")
+		}
+	}
+	return p.client, nil
+}
+
+// tokenSource reads the profile's token the same way as the token flags.
+func (p *tokenProfile) tokenSource(tokens tokenOptions) (oauth2.TokenSource, error) {
+	ts, err := tokens.TokenSource()
+	if err != nil {
+		return nil, errors.Wrapf(err, "token profile `%s`", p.name)
+	}
+	if ts == nil {
+		return nil, errors.Errorf("token profile `%s`: %s is not set", p.name, tokens.env)
+	}
+	return ts, nil
+}
@@ -0,0 +1,204 @@
+package src
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `
+token-profiles:
+  partner:
+    source-token-env: TEST_PARTNER_SOURCE_TOKEN
+    destination-token-env: TEST_PARTNER_DESTINATION_TOKEN
+repositories:
+  - repo: actions/checkout
+    refs: [v4, v3.6.0]
+  - repo: partner/deploy-action
+    destination: myorg/deploy-action
+    source-url: https://git.partner.example.com
+    token-profile: partner
+    default-branch-only: true
+    include-refs: ["refs/tags/v*"]
+    tags-semver: ">=2"
+    batch-size: 50
+    visibility: internal
+  - repo: https://gitlab.example.com/group/action.git
+    destination: myorg/action
+`
+
+func writeTestManifest(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	return file
+}
+
+func TestLoadManifest(t *testing.T) {
+	m, err := loadManifest(writeTestManifest(t, "repos.yml", testManifest))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"actions/checkout@v4,v3.6.0",
+		"partner/deploy-action:myorg/deploy-action",
+		"https://gitlab.example.com/group/action.git=>myorg/action",
+	}, m.repoNames())
+	entry := m.entry("partner/deploy-action:myorg/deploy-action")
+	require.NotNil(t, entry)
+	assert.Same(t, m.TokenProfiles["partner"], entry.profile)
+	assert.Equal(t, "partner", entry.profile.name)
+	assert.Nil(t, m.entry("actions/setup-go"))
+	assert.Nil(t, (*manifest)(nil).entry("actions/checkout"))
+}
+
+func TestLoadManifest_JSON(t *testing.T) {
+	m, err := loadManifest(writeTestManifest(t, "repos.json", `{
+  "repositories": [
+    {"repo": "actions/checkout", "destination": "myorg/checkout", "default-branch-only": false}
+  ]
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout:myorg/checkout"}, m.repoNames())
+	entry := m.entry("actions/checkout:myorg/checkout")
+	require.NotNil(t, entry.DefaultBranchOnly)
+	assert.False(t, *entry.DefaultBranchOnly)
+}
+
+func TestLoadManifest_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown key":        "repositories:\n  - repo: actions/checkout\n    visibilty: private\n",
+		"no repo":            "repositories:\n  - destination: myorg/checkout\n",
+		"bad visibility":     "repositories:\n  - repo: actions/checkout\n    visibility: secret\n",
+		"small batch size":   "repositories:\n  - repo: actions/checkout\n    batch-size: 5\n",
+		"undefined profile":  "repositories:\n  - repo: actions/checkout\n    token-profile: nobody\n",
+		"wildcard":           "repositories:\n  - repo: actions/*\n",
+		"duplicate":          "repositories:\n  - repo: actions/checkout\n  - repo: actions/checkout\n",
+		"bad semver":         "repositories:\n  - repo: actions/checkout\n    tags-semver: latest\n",
+		"two source tokens":  "token-profiles:\n  p:\n    source-token-env: A\n    source-token-file: b\nrepositories:\n  - repo: actions/checkout\n",
+		"token over http":    "token-profiles:\n  p:\n    source-token-env: A\nrepositories:\n  - repo: actions/checkout\n    source-url: http://git.example.com\n    token-profile: p\n",
+		"nested destination": "repositories:\n  - repo: group/sub/repo\n    destination: myorg/sub/repo\n",
+	} {
+		_, err := loadManifest(writeTestManifest(t, "repos.yaml", content))
+		assert.Error(t, err, name)
+	}
+
+	_, err := loadManifest(writeTestManifest(t, "repos.yaml", "repositories: []\n"))
+	assert.ErrorIs(t, err, ErrEmptyRepoList)
+}
+
+func TestGetRepoNamesFromRepoFlags_Manifest(t *testing.T) {
+	flags := &CommonFlags{RepoNameListFile: writeTestManifest(t, "repos.yml", testManifest)}
+	repoNames, err := getRepoNamesFromRepoFlags(flags)
+	require.NoError(t, err)
+	assert.Len(t, repoNames, 3)
+	require.NotNil(t, flags.manifest)
+
+	// other extensions are still read as plain lists
+	flags = &CommonFlags{RepoNameListFile: writeTestManifest(t, "repos.txt", "actions/checkout\n")}
+	repoNames, err = getRepoNamesFromRepoFlags(flags)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/checkout"}, repoNames)
+	assert.Nil(t, flags.manifest)
+}
+
+func TestManifestEntry_PullFlags(t *testing.T) {
+	t.Setenv("TEST_PARTNER_SOURCE_TOKEN", "partner-token")
+	m, err := loadManifest(writeTestManifest(t, "repos.yml", testManifest))
+	require.NoError(t, err)
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.manifest = m
+	flags.IncludeRefs = []string{"refs/heads/main"}
+	auth := gitAuthMethod("default-token")
+
+	repoFlags, repoAuth, err := flags.forRepo("partner/deploy-action:myorg/deploy-action", auth)
+	require.NoError(t, err)
+	assert.Equal(t, "https://git.partner.example.com", repoFlags.SourceURL)
+	assert.True(t, repoFlags.DefaultBranchOnly)
+	assert.Equal(t, []string{"refs/tags/v*"}, repoFlags.IncludeRefs)
+	assert.Equal(t, ">=2", repoFlags.TagsSemver)
+	tsAuth, ok := repoAuth.(*tokenSourceAuth)
+	require.True(t, ok)
+	token, err := tsAuth.ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "partner-token", token.AccessToken)
+	assert.Equal(t, tsAuth.ts, repoFlags.tokenSource, "a rejected token can be refreshed")
+	// the flags themselves are left alone
+	assert.Equal(t, "https://github.com", flags.SourceURL)
+	assert.False(t, flags.DefaultBranchOnly)
+
+	// URL entries are left to sourceAuth, which only sends the token to
+	// --source-url
+	_, repoAuth, err = flags.forRepo("https://gitlab.example.com/group/action.git=>myorg/action", auth)
+	require.NoError(t, err)
+	assert.Same(t, auth, repoAuth)
+
+	repoFlags, repoAuth, err = flags.forRepo("actions/checkout@v4,v3.6.0", auth)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"refs/heads/main"}, repoFlags.IncludeRefs, "flags are the defaults")
+	assert.Same(t, auth, repoAuth)
+
+	repoFlags, repoAuth, err = flags.forRepo("actions/setup-go", auth)
+	require.NoError(t, err)
+	assert.Same(t, flags, repoFlags)
+	assert.Same(t, auth, repoAuth)
+}
+
+func TestManifestEntry_PullFlags_OtherSourceDropsAuth(t *testing.T) {
+	m, err := loadManifest(writeTestManifest(t, "repos.yml", "repositories:\n  - repo: group/action\n    source-url: https://git.example.com\n"))
+	require.NoError(t, err)
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.manifest = m
+
+	repoFlags, repoAuth, err := flags.forRepo("group/action", gitAuthMethod("default-token"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://git.example.com", repoFlags.SourceURL)
+	assert.Nil(t, repoAuth, "the --source-url token must not be sent to another host")
+}
+
+func TestPullManyWithGitImpl_ManifestOptions(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{headBranch: "main", branches: []string{"main", "feature"}}
+	impl := &fakePullGitImpl{repo: repo}
+	m, err := loadManifest(writeTestManifest(t, "repos.yml", "repositories:\n  - repo: actions/setup-node\n    default-branch-only: true\n"))
+	require.NoError(t, err)
+	flags := newTestPullFlags(cacheDir, false)
+	flags.manifest = m
+
+	err = PullManyWithGitImpl(context.Background(), flags, nil, m.repoNames(), impl)
+	require.NoError(t, err)
+	assert.True(t, impl.cloneSingleBranch, "the entry's default-branch-only overrides the flag")
+}
+
+func TestManifestEntry_PushFlags(t *testing.T) {
+	t.Setenv("TEST_PARTNER_DESTINATION_TOKEN", "partner-destination-token")
+	m, err := loadManifest(writeTestManifest(t, "repos.yml", testManifest))
+	require.NoError(t, err)
+	flags := &PushFlags{
+		CommonFlags:   CommonFlags{manifest: m},
+		PushOnlyFlags: PushOnlyFlags{BaseURL: "https://ghes.example.com", Token: "default-token", BatchSize: 100},
+	}
+	client := newTestGitHubClient(t, "https://ghes.example.com")
+
+	repoFlags, repoClient, err := flags.forRepo("partner/deploy-action:myorg/deploy-action", client)
+	require.NoError(t, err)
+	assert.Equal(t, 50, repoFlags.BatchSize)
+	assert.Equal(t, "internal", repoFlags.visibility)
+	token, err := repoFlags.tokenSource.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "partner-destination-token", token.AccessToken)
+	assert.Empty(t, repoFlags.Token)
+	assert.NotSame(t, client, repoClient)
+	assert.Equal(t, "default-token", flags.Token)
+	_, profileClient, err := flags.forRepo("partner/deploy-action:myorg/deploy-action", client)
+	require.NoError(t, err)
+	assert.Same(t, repoClient, profileClient, "the profile's client is shared by its repositories")
+
+	repoFlags, repoClient, err = flags.forRepo("actions/checkout@v4,v3.6.0", client)
+	require.NoError(t, err)
+	assert.Equal(t, 100, repoFlags.BatchSize)
+	assert.Empty(t, repoFlags.visibility)
+	assert.Same(t, client, repoClient)
+}
@@ -0,0 +1,54 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// outputMu serialises whole lines written by concurrent prefixWriters so lines
+// from different repositories never interleave mid-line.
+var outputMu sync.Mutex
+
+// prefixWriter buffers output until a full line is available and then writes
+// it to the underlying writer prefixed with `[prefix] `.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: fmt.Sprintf("[%s] ", prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf.Next(i + 1)); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes any trailing partial line, terminating it with a newline.
+func (p *prefixWriter) Flush() {
+	if p.buf.Len() == 0 {
+		return
+	}
+	line := append(p.buf.Next(p.buf.Len()), '\n')
+	_ = p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
@@ -0,0 +1,31 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriter_PrefixesCompleteLines(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter(&buf, "actions/checkout")
+
+	fmt.Fprintf(w, "pulling ")
+	assert.Empty(t, buf.String(), "partial lines should be buffered")
+
+	fmt.Fprintf(w, "actions/checkout ...\nfetching all branches and tags ...\n")
+	assert.Equal(t, "[actions/checkout] pulling actions/checkout ...\n[actions/checkout] fetching all branches and tags ...\n", buf.String())
+}
+
+func TestPrefixWriter_FlushTerminatesPartialLine(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter(&buf, "actions/checkout")
+
+	fmt.Fprintf(w, "no newline")
+	w.Flush()
+	w.Flush()
+
+	assert.Equal(t, "[actions/checkout] no newline\n", buf.String())
+}
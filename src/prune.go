@@ -0,0 +1,154 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// DefaultPruneThreshold is the largest share of refs, in percent, that --prune
+// deletes from a single repository before refusing to go ahead.
+const DefaultPruneThreshold = 20
+
+// refsToPrune returns the branches and tags in existing that are missing from
+// keep. Only refs in the selection are considered, so narrowing what is synced
+// never deletes the refs left out. It fails without returning anything when
+// more than --prune-threshold percent of the refs considered would be removed,
+// which usually means the other side is broken rather than cleaned up. With
+// --prune-min-refs that many refs may be removed whatever their share, as long
+// as some are kept.
+func refsToPrune(existing []*plumbing.Reference, keep map[plumbing.ReferenceName]bool, selection *refSelection, flags *CommonFlags) ([]plumbing.ReferenceName, error) {
+	inScope := selection.Select(existing, nil)
+
+	var prune []plumbing.ReferenceName
+	for _, name := range inScope {
+		if !keep[name] {
+			prune = append(prune, name)
+		}
+	}
+
+	allowed := len(prune) <= flags.PruneMinRefs && len(prune) < len(inScope)
+	if !allowed && len(prune)*100 > flags.PruneThreshold*len(inScope) {
+		return nil, errors.Errorf("refusing to prune %d of %d refs, more than the --prune-threshold of %d%%", len(prune), len(inScope), flags.PruneThreshold)
+	}
+	return prune, nil
+}
+
+// pruneCachedRefs deletes the cached branches and tags that no longer exist on
+// the origin remote.
+func pruneCachedRefs(ctx context.Context, repo GitRepository, origin *originRefs, selection *refSelection, flags *CommonFlags, out io.Writer) error {
+	remoteRefs, _, err := origin.list(ctx)
+	if err != nil {
+		return err
+	}
+	upstream := map[plumbing.ReferenceName]bool{}
+	for _, ref := range remoteRefs {
+		upstream[ref.Name()] = true
+	}
+
+	cached, err := hashReferences(repo)
+	if err != nil {
+		return err
+	}
+	prune, err := refsToPrune(cached, upstream, selection, flags)
+	if err != nil {
+		return err
+	}
+	for _, name := range prune {
+		if err := repo.RemoveReference(name); err != nil {
+			return errors.Wrapf(err, "error pruning %s", name)
+		}
+		fmt.Fprintf(out, "pruned %s, it no longer exists upstream\n", name)
+	}
+	return nil
+}
+
+// listDestinationRefs lists the refs on remote, none when the repository is
+// still empty.
+func listDestinationRefs(ctx context.Context, remote GitRemote, dest endpoint) ([]*plumbing.Reference, error) {
+	destRefs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:            dest.auth,
+		CABundle:        dest.caBundle,
+		InsecureSkipTLS: dest.insecure,
+		ProxyOptions:    dest.proxy,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(withTokenError(dest.auth, err), "error listing destination refs")
+	}
+	return destRefs, nil
+}
+
+// pruneDestinationRefs deletes the branches and tags in destRefs, listed from
+// remote, that aren't in the cached repository. It returns the refs remote is
+// left with.
+func pruneDestinationRefs(ctx context.Context, remote GitRemote, repo GitRepository, destRefs []*plumbing.Reference, dest endpoint, selection *refSelection, flags *CommonFlags, out io.Writer) ([]*plumbing.Reference, error) {
+	cached, err := hashReferences(repo)
+	if err != nil {
+		return nil, err
+	}
+	keep := map[plumbing.ReferenceName]bool{}
+	for _, ref := range cached {
+		keep[ref.Name()] = true
+	}
+
+	prune, err := refsToPrune(destRefs, keep, selection, flags)
+	if err != nil {
+		return nil, err
+	}
+	if len(prune) == 0 {
+		return destRefs, nil
+	}
+
+	pruned := map[plumbing.ReferenceName]bool{}
+	refSpecs := make([]config.RefSpec, len(prune))
+	for i, name := range prune {
+		pruned[name] = true
+		refSpecs[i] = config.RefSpec(":" + name.String())
+		fmt.Fprintf(out, "pruning %s, it is not in the cache\n", name)
+	}
+	err = remote.PushContext(ctx, &git.PushOptions{
+		RemoteName:      remote.Config().Name,
+		RefSpecs:        refSpecs,
+		Auth:            dest.auth,
+		CABundle:        dest.caBundle,
+		InsecureSkipTLS: dest.insecure,
+		ProxyOptions:    dest.proxy,
+	})
+	if err != nil && errors.Cause(err) != git.NoErrAlreadyUpToDate {
+		return nil, errors.Wrap(withTokenError(dest.auth, err), "error pruning destination refs")
+	}
+
+	var left []*plumbing.Reference
+	for _, ref := range destRefs {
+		if !pruned[ref.Name()] {
+			left = append(left, ref)
+		}
+	}
+	return left, nil
+}
+
+// hashReferences returns the refs in the repository that point straight at an
+// object, leaving out symbolic refs such as HEAD.
+func hashReferences(repo GitRepository) ([]*plumbing.Reference, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	return refs, err
+}
@@ -0,0 +1,150 @@
+package src
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v43/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefsToPrune(t *testing.T) {
+	existing := testRemoteRefs("refs/heads/main", "refs/heads/old", "refs/tags/v1.0.0", "refs/tags/v0.9.0", "refs/pull/1/head")
+	keep := map[plumbing.ReferenceName]bool{"refs/heads/main": true, "refs/tags/v1.0.0": true}
+
+	prune, err := refsToPrune(existing, keep, nil, &CommonFlags{PruneThreshold: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{"refs/heads/old", "refs/tags/v0.9.0"}, prune, "only missing branches and tags should be pruned")
+}
+
+func TestRefsToPrune_Threshold(t *testing.T) {
+	existing := testRemoteRefs("refs/heads/main", "refs/heads/a", "refs/heads/b", "refs/heads/c")
+	keep := map[plumbing.ReferenceName]bool{"refs/heads/main": true}
+
+	_, err := refsToPrune(existing, keep, nil, &CommonFlags{PruneThreshold: 50})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to prune 3 of 4 refs")
+
+	prune, err := refsToPrune(existing, keep, nil, &CommonFlags{PruneThreshold: 75})
+	require.NoError(t, err)
+	assert.Len(t, prune, 3)
+
+	// the threshold applies to small repositories too
+	keep["refs/heads/a"] = true
+	_, err = refsToPrune(existing, keep, nil, &CommonFlags{PruneThreshold: DefaultPruneThreshold})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to prune 2 of 4 refs")
+	_, err = refsToPrune(testRemoteRefs("refs/heads/main", "refs/heads/a"), map[plumbing.ReferenceName]bool{"refs/heads/main": true}, nil, &CommonFlags{})
+	require.Error(t, err, "a threshold of 0 deletes nothing")
+}
+
+func TestRefsToPrune_MinRefs(t *testing.T) {
+	existing := testRemoteRefs("refs/heads/main", "refs/heads/a", "refs/heads/b", "refs/heads/c")
+	keep := map[plumbing.ReferenceName]bool{"refs/heads/main": true, "refs/heads/a": true}
+
+	// --prune-min-refs lets a few refs go whatever their share
+	prune, err := refsToPrune(existing, keep, nil, &CommonFlags{PruneThreshold: DefaultPruneThreshold, PruneMinRefs: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{"refs/heads/b", "refs/heads/c"}, prune)
+
+	_, err = refsToPrune(existing, map[plumbing.ReferenceName]bool{"refs/heads/main": true}, nil, &CommonFlags{PruneThreshold: DefaultPruneThreshold, PruneMinRefs: 2})
+	require.Error(t, err, "but no more than that")
+
+	_, err = refsToPrune(testRemoteRefs("refs/heads/a", "refs/heads/b"), map[plumbing.ReferenceName]bool{}, nil, &CommonFlags{PruneThreshold: DefaultPruneThreshold, PruneMinRefs: 2})
+	require.Error(t, err, "and not every ref")
+	assert.Contains(t, err.Error(), "refusing to prune 2 of 2 refs")
+}
+
+func TestRefsToPrune_OnlyConsidersSelectedRefs(t *testing.T) {
+	existing := testRemoteRefs("refs/heads/main", "refs/heads/old", "refs/tags/v1.0.0", "refs/tags/v0.9.0")
+	keep := map[plumbing.ReferenceName]bool{"refs/heads/main": true, "refs/tags/v1.0.0": true}
+	selection, err := newRefSelection(&CommonFlags{IncludeRefs: []string{"refs/tags/*"}})
+	require.NoError(t, err)
+
+	prune, err := refsToPrune(existing, keep, selection, &CommonFlags{PruneThreshold: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{"refs/tags/v0.9.0"}, prune, "branches outside the selection should be left alone")
+}
+
+func TestPullWithGitImpl_Prune(t *testing.T) {
+	srcDir := path.Join(t.TempDir(), "actions", "checkout")
+	hash := initTestRepository(t, srcDir)
+	src, err := git.PlainOpen(srcDir)
+	require.NoError(t, err)
+	require.NoError(t, src.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", hash)))
+	cacheDir := t.TempDir()
+
+	flags := newTestPullFlags(cacheDir, false)
+	flags.SourceURL = "file://" + path.Dir(path.Dir(srcDir))
+	flags.PruneThreshold = 50
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+
+	require.NoError(t, src.Storer.RemoveReference("refs/heads/feature"))
+
+	// Without --prune the deleted branch stays in the cache
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	cache, err := git.PlainOpen(path.Join(cacheDir, "actions", "checkout"))
+	require.NoError(t, err)
+	_, err = cache.Reference("refs/heads/feature", false)
+	require.NoError(t, err)
+
+	flags.Prune = true
+	var out strings.Builder
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "pruned refs/heads/feature")
+
+	cache, err = git.PlainOpen(path.Join(cacheDir, "actions", "checkout"))
+	require.NoError(t, err)
+	_, err = cache.Reference("refs/heads/feature", false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = cache.Reference("refs/heads/main", false)
+	assert.NoError(t, err)
+	_, err = cache.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err)
+}
+
+func TestSyncWithCachedRepository_Prune(t *testing.T) {
+	repoDir := path.Join(t.TempDir(), "actions", "checkout")
+	hash := initTestRepository(t, repoDir)
+	destDir := t.TempDir()
+	dest, err := git.PlainInit(destDir, true)
+	require.NoError(t, err)
+	cloneURL := "file://" + destDir
+	ghRepo := &github.Repository{CloneURL: &cloneURL}
+
+	flags := &PushFlags{PushOnlyFlags: PushOnlyFlags{DisableGitAuth: true}}
+	_, err = syncWithCachedRepository(context.Background(), flags, ghRepo, repoDir, nil, io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	require.NoError(t, dest.Storer.SetReference(plumbing.NewHashReference("refs/heads/stale", hash)))
+
+	flags.Prune = true
+	flags.PruneThreshold = 50
+	var out strings.Builder
+	_, err = syncWithCachedRepository(context.Background(), flags, ghRepo, repoDir, nil, &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "pruning refs/heads/stale")
+
+	_, err = dest.Reference("refs/heads/stale", false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = dest.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err, "refs from the cache should still be pushed")
+}
+
+func TestPruneDestinationRefs_AbortsOverThreshold(t *testing.T) {
+	repo := &mockGitRepository{refs: testRemoteRefs("refs/heads/main")}
+	remote := &mockGitRemote{listRefs: testRemoteRefs("refs/heads/main", "refs/heads/a", "refs/heads/b", "refs/heads/c")}
+
+	_, err := pruneDestinationRefs(context.Background(), remote, repo, remote.listRefs, endpoint{}, nil, &CommonFlags{PruneThreshold: DefaultPruneThreshold}, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--prune-threshold")
+	assert.Empty(t, remote.pushCalls, "nothing should be deleted when the threshold is exceeded")
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/go-git/go-git/v5"
@@ -12,6 +14,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
 func TestGitAuthMethod_EmptyTokenIsAnonymous(t *testing.T) {
@@ -68,7 +71,7 @@ func TestPullWithGitImpl_ThreadsAuthToCloneAndFetch(t *testing.T) {
 	impl := &fakePullGitImpl{repo: repo}
 	auth := gitAuthMethod("secret-token")
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", auth, cacheDir, false, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), auth, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.Same(t, auth, impl.cloneAuth, "clone should use the provided auth")
@@ -81,7 +84,7 @@ func TestPullWithGitImpl_NilAuthWhenNoToken(t *testing.T) {
 	repo := &fakePullRepo{}
 	impl := &fakePullGitImpl{repo: repo}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, false, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), nil, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.Nil(t, impl.cloneAuth)
@@ -94,7 +97,7 @@ func TestPullWithGitImpl_SkipsCloneWhenRepositoryExists(t *testing.T) {
 	impl := &fakePullGitImpl{repo: repo, exists: true}
 	auth := gitAuthMethod("secret-token")
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", auth, cacheDir, false, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), auth, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.Nil(t, impl.cloneAuth, "clone should be skipped when the repo already exists")
@@ -105,7 +108,7 @@ func TestPullWithGitImpl_AuthRequiredReturnsFriendlyError(t *testing.T) {
 	cacheDir := t.TempDir()
 	impl := &fakePullGitImpl{repo: &fakePullRepo{}, cloneErr: errors.New("authentication required")}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, false, "actions/private", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), nil, "actions/private", io.Discard, impl)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "may require authentication or does not exist")
 }
@@ -115,17 +118,59 @@ func TestPullWithGitImpl_FetchAuthRequiredReturnsFriendlyError(t *testing.T) {
 	repo := &fakePullRepo{fetchErr: errors.New("authentication required")}
 	impl := &fakePullGitImpl{repo: repo, exists: true}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, false, "actions/private", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), nil, "actions/private", io.Discard, impl)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "may require authentication or does not exist")
 }
 
+// fakeRefreshingTokenSource counts refreshes and runs onRefresh on each.
+type fakeRefreshingTokenSource struct {
+	refreshes int
+	onRefresh func()
+}
+
+func (s *fakeRefreshingTokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", s.refreshes)}, nil
+}
+
+func (s *fakeRefreshingTokenSource) Generation() uint64 {
+	return uint64(s.refreshes)
+}
+
+func (s *fakeRefreshingTokenSource) Refresh(generation uint64) error {
+	if generation != uint64(s.refreshes) {
+		return nil
+	}
+	s.refreshes++
+	s.onRefresh()
+	return nil
+}
+
+func TestPullManyWithGitImpl_RefreshesRejectedSourceToken(t *testing.T) {
+	repo := &fakePullRepo{fetchErr: errors.New("authentication required")}
+	impl := &fakePullGitImpl{repo: repo, exists: true}
+	ts := &fakeRefreshingTokenSource{onRefresh: func() { repo.fetchErr = nil }}
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.tokenSource = ts
+
+	err := PullManyWithGitImpl(context.Background(), flags, tokenSourceGitAuth(ts), []string{"actions/private"}, impl)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ts.refreshes)
+
+	repo.fetchErr = errors.New("authentication required")
+	ts.onRefresh = func() {}
+	err = PullManyWithGitImpl(context.Background(), flags, tokenSourceGitAuth(ts), []string{"actions/private"}, impl)
+	require.Error(t, err)
+	assert.Equal(t, 2, ts.refreshes, "a token that is rejected again isn't refreshed twice")
+	assert.Contains(t, err.Error(), "may require authentication or does not exist")
+}
+
 func TestPullManyWithGitImpl_ThreadsAuthToEachRepo(t *testing.T) {
 	cacheDir := t.TempDir()
 	impl := &fakePullGitImpl{repo: &fakePullRepo{}}
 	auth := gitAuthMethod("secret-token")
 
-	err := PullManyWithGitImpl(context.Background(), "https://github.com", auth, cacheDir, false, []string{"actions/a", "actions/b"}, impl)
+	err := PullManyWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), auth, []string{"actions/a", "actions/b"}, impl)
 	require.NoError(t, err)
 
 	assert.Equal(t, 2, impl.cloneCount, "each repo should be cloned")
@@ -137,7 +182,7 @@ func TestPullManyWithGitImpl_StopsOnFirstError(t *testing.T) {
 	cacheDir := t.TempDir()
 	impl := &fakePullGitImpl{repo: &fakePullRepo{}, cloneErr: errors.New("boom")}
 
-	err := PullManyWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, false, []string{"actions/a", "actions/b"}, impl)
+	err := PullManyWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), nil, []string{"actions/a", "actions/b"}, impl)
 	require.Error(t, err)
 	assert.Equal(t, 1, impl.cloneCount, "iteration should stop after the first failing repo")
 }
@@ -147,7 +192,7 @@ func TestPullWithGitImpl_AllBranchesByDefault(t *testing.T) {
 	repo := &fakePullRepo{}
 	impl := &fakePullGitImpl{repo: repo}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, false, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, false), nil, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.False(t, impl.cloneSingleBranch, "clone should not be limited to a single branch")
@@ -167,7 +212,7 @@ func TestPullWithGitImpl_DefaultBranchOnly(t *testing.T) {
 	}
 	impl := &fakePullGitImpl{repo: repo}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, true, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, true), nil, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.True(t, impl.cloneSingleBranch, "clone should be limited to the default branch")
@@ -188,7 +233,7 @@ func TestPullWithGitImpl_DefaultBranchOnlyRefreshesCachedBranchOnReSync(t *testi
 	}
 	impl := &fakePullGitImpl{repo: repo, exists: true}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, true, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, true), nil, "actions/setup-node", io.Discard, impl)
 	require.NoError(t, err)
 
 	assert.Equal(t, 0, impl.cloneCount, "clone should be skipped when the repo already exists")
@@ -201,7 +246,7 @@ func TestPullWithGitImpl_DefaultBranchOnlyHeadError(t *testing.T) {
 	repo := &fakePullRepo{headErr: errors.New("reference not found")}
 	impl := &fakePullGitImpl{repo: repo, exists: true}
 
-	err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, true, "actions/setup-node", impl)
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, true), nil, "actions/setup-node", io.Discard, impl)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "default branch")
 	assert.False(t, repo.fetchCalled, "fetch should not run when the default branch cannot be resolved")
@@ -215,7 +260,7 @@ func TestPullManyWithGitImpl_ThreadsDefaultBranchOnlyToEachRepo(t *testing.T) {
 	}
 	impl := &fakePullGitImpl{repo: repo}
 
-	err := PullManyWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, true, []string{"actions/a", "actions/b"}, impl)
+	err := PullManyWithGitImpl(context.Background(), newTestPullFlags(cacheDir, true), nil, []string{"actions/a", "actions/b"}, impl)
 	require.NoError(t, err)
 
 	assert.True(t, impl.cloneSingleBranch, "clone should be limited to the default branch for each repo")
@@ -230,10 +275,250 @@ func TestPullWithGitImpl_TagsAlwaysSynced(t *testing.T) {
 			repo := &fakePullRepo{}
 			impl := &fakePullGitImpl{repo: repo}
 
-			err := PullWithGitImpl(context.Background(), "https://github.com", nil, cacheDir, defaultBranchOnly, "actions/setup-node", impl)
+			err := PullWithGitImpl(context.Background(), newTestPullFlags(cacheDir, defaultBranchOnly), nil, "actions/setup-node", io.Discard, impl)
 			require.NoError(t, err)
 
 			assert.Equal(t, git.AllTags, repo.fetchTags, "all tags should be fetched regardless of default-branch-only")
 		})
 	}
 }
+
+func TestPullOnlyFlags_Validate_NegativeConcurrencyRejected(t *testing.T) {
+	f := &PullOnlyFlags{SourceURL: "https://github.com", Concurrency: -1}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--concurrency")
+}
+
+func TestPullManyWithGitImpl_Concurrent(t *testing.T) {
+	cacheDir := t.TempDir()
+	impl := &fakePullGitImpl{repo: &fakePullRepo{}}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.Concurrency = 3
+
+	repoNames := []string{"actions/a", "actions/b", "actions/c", "actions/d", "actions/e"}
+	err := PullManyWithGitImpl(context.Background(), flags, nil, repoNames, impl)
+	require.NoError(t, err)
+
+	assert.Equal(t, len(repoNames), impl.cloneCount, "each repo should be cloned once")
+}
+
+func TestPullManyWithGitImpl_ConcurrentReturnsError(t *testing.T) {
+	cacheDir := t.TempDir()
+	impl := &fakePullGitImpl{repo: &fakePullRepo{}, cloneErr: errors.New("boom")}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.Concurrency = 2
+
+	err := PullManyWithGitImpl(context.Background(), flags, nil, []string{"actions/a", "actions/b", "actions/c"}, impl)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+}
+
+func TestPullManyWithGitImpl_ContinueOnErrorPullsEveryRepo(t *testing.T) {
+	cacheDir := t.TempDir()
+	impl := &fakePullGitImpl{repo: &fakePullRepo{}, cloneErr: errors.New("boom")}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.ContinueOnError = true
+
+	err := PullManyWithGitImpl(context.Background(), flags, nil, []string{"actions/a", "actions/b"}, impl)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 2 repositories failed")
+	assert.Equal(t, 2, impl.cloneCount, "iteration should continue past failing repos")
+}
+
+func testRemoteRefs(names ...string) []*plumbing.Reference {
+	refs := []*plumbing.Reference{plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))}
+	for _, name := range names {
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.ZeroHash))
+	}
+	return refs
+}
+
+func TestPullWithGitImpl_RefFiltersDriveFetchRefSpecs(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{remoteRefs: testRemoteRefs(
+		"refs/heads/main",
+		"refs/heads/dependabot/npm_and_yarn/foo",
+		"refs/heads/feature",
+		"refs/tags/v1.0.0",
+		"refs/tags/release-1",
+	)}
+	impl := &fakePullGitImpl{repo: repo}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.IncludeRefs = []string{"refs/tags/v*", "refs/heads/*", "!refs/heads/dependabot/*"}
+	flags.ExcludeRefs = []string{"refs/heads/feature"}
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+
+	assert.True(t, impl.cloneSingleBranch, "a filtered clone should only bring down the default branch")
+	assert.Equal(t, git.NoTags, impl.cloneTags, "a filtered clone should not fetch every tag")
+	assert.Equal(t, []config.RefSpec{
+		"+refs/heads/main:refs/heads/main",
+		"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+	}, repo.fetchRefSpecs)
+	assert.Equal(t, git.NoTags, repo.fetchTags, "tags should only be fetched through the filtered refspecs")
+}
+
+func TestPullWithGitImpl_ListsOriginOnce(t *testing.T) {
+	repo := &fakePullRepo{
+		branches:   []string{"main"},
+		remoteRefs: testRemoteRefs("refs/heads/main", "refs/tags/v1.0.0"),
+	}
+	impl := &fakePullGitImpl{repo: repo, exists: true}
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.IncludeRefs = []string{"refs/heads/*", "refs/tags/*"}
+	flags.Prune = true
+	flags.PruneThreshold = DefaultPruneThreshold
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.remoteCalls, "pruning, ref selection and moved tag detection share one listing")
+}
+
+func TestPullWithGitImpl_RefFiltersWithDefaultBranchOnly(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{
+		headBranch: "trunk",
+		remoteRefs: testRemoteRefs("refs/heads/trunk", "refs/heads/main", "refs/tags/v2"),
+	}
+	impl := &fakePullGitImpl{repo: repo, exists: true}
+	flags := newTestPullFlags(cacheDir, true)
+	flags.IncludeRefs = []string{"refs/heads/*", "refs/tags/*"}
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+
+	assert.Equal(t, []config.RefSpec{
+		"+refs/heads/trunk:refs/heads/trunk",
+		"+refs/tags/v2:refs/tags/v2",
+	}, repo.fetchRefSpecs)
+}
+
+func TestPullWithGitImpl_RefFiltersMatchingNothingSkipsFetch(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{remoteRefs: testRemoteRefs("refs/heads/main", "refs/tags/v1")}
+	impl := &fakePullGitImpl{repo: repo, exists: true}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.IncludeRefs = []string{"refs/tags/release-*"}
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+	assert.False(t, repo.fetchCalled)
+}
+
+func TestPullWithGitImpl_SemverSelectionUsesPeeledTags(t *testing.T) {
+	// v4.1.0 is an annotated tag, so the floating v4 tag (a lightweight tag on
+	// the release commit) must be matched through the peeled target.
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{remoteRefs: []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.NewHash("c2")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v3.6.0"), plumbing.NewHash("b2")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v3"), plumbing.NewHash("b2")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v4.1.0"), plumbing.NewHash("ff")),
+		plumbing.NewHashReference("refs/tags/v4.1.0^{}", plumbing.NewHash("c2")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v4"), plumbing.NewHash("c2")),
+	}}
+	impl := &fakePullGitImpl{repo: repo}
+	flags := newTestPullFlags(cacheDir, false)
+	flags.KeepLatestMajors = 1
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, impl)
+	require.NoError(t, err)
+
+	assert.Equal(t, git.NoTags, impl.cloneTags)
+	assert.Equal(t, []config.RefSpec{
+		"+refs/heads/main:refs/heads/main",
+		"+refs/tags/v4.1.0:refs/tags/v4.1.0",
+		"+refs/tags/v4:refs/tags/v4",
+	}, repo.fetchRefSpecs)
+}
+
+func TestPullWithGitImpl_PinnedRefs(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{remoteRefs: testRemoteRefs(
+		"refs/heads/main",
+		"refs/heads/v4",
+		"refs/tags/v4",
+		"refs/tags/v3.6.0",
+		"refs/tags/v3.5.0",
+	)}
+	impl := &fakePullGitImpl{repo: repo}
+	flags := newTestPullFlags(cacheDir, false)
+	// Pins replace the global selection for the entry
+	flags.IncludeRefs = []string{"refs/tags/v3.5.0"}
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout@v4,v3.6.0:myorg/checkout", io.Discard, impl)
+	require.NoError(t, err)
+
+	assert.Equal(t, git.NoTags, impl.cloneTags)
+	assert.Equal(t, []config.RefSpec{
+		"+refs/heads/v4:refs/heads/v4",
+		"+refs/tags/v4:refs/tags/v4",
+		"+refs/tags/v3.6.0:refs/tags/v3.6.0",
+	}, repo.fetchRefSpecs)
+}
+
+func TestPullWithGitImpl_PinnedRefsWithDefaultBranch(t *testing.T) {
+	cacheDir := t.TempDir()
+	repo := &fakePullRepo{
+		headBranch: "main",
+		remoteRefs: testRemoteRefs("refs/heads/main", "refs/heads/releases/v1", "refs/heads/feature", "refs/tags/v1.0.0"),
+	}
+	impl := &fakePullGitImpl{repo: repo, exists: true}
+	flags := newTestPullFlags(cacheDir, true)
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout@releases/v1,v1.0.0", io.Discard, impl)
+	require.NoError(t, err)
+
+	assert.Equal(t, []config.RefSpec{
+		"+refs/heads/main:refs/heads/main",
+		"+refs/heads/releases/v1:refs/heads/releases/v1",
+		"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+	}, repo.fetchRefSpecs)
+}
+
+func TestPullWithGitImpl_ClonesBareByDefault(t *testing.T) {
+	impl := &fakePullGitImpl{repo: &fakePullRepo{}}
+
+	err := PullWithGitImpl(context.Background(), newTestPullFlags(t.TempDir(), false), nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+	assert.True(t, impl.cloneBare)
+
+	impl = &fakePullGitImpl{repo: &fakePullRepo{}}
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.CacheLayout = CacheLayoutWorktree
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+	assert.False(t, impl.cloneBare)
+}
+
+func TestPullWithGitImpl_MigrateCache(t *testing.T) {
+	repo := &fakePullRepo{}
+	impl := &fakePullGitImpl{repo: repo, exists: true, converted: true}
+	flags := newTestPullFlags(t.TempDir(), false)
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", io.Discard, impl)
+	require.NoError(t, err)
+	assert.Equal(t, 0, impl.convertCount, "the cache should only be migrated with --migrate-cache")
+
+	flags.MigrateCache = true
+	var out strings.Builder
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/setup-node", &out, impl)
+	require.NoError(t, err)
+	assert.Equal(t, 1, impl.convertCount)
+	assert.Contains(t, out.String(), "converted")
+	assert.True(t, repo.fetchCalled, "the migrated repository should still be fetched")
+}
+
+func TestPullOnlyFlags_Validate_CacheLayout(t *testing.T) {
+	f := &PullOnlyFlags{SourceURL: "https://github.com", CacheLayout: "shallow"}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--cache-layout")
+
+	f = &PullOnlyFlags{SourceURL: "https://github.com", CacheLayout: CacheLayoutWorktree, MigrateCache: true}
+	validations = f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--migrate-cache")
+}
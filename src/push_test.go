@@ -1,12 +1,17 @@
 package src
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -156,7 +161,7 @@ func TestCollectRefs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := &mockGitRepository{refs: tt.refs}
 
-			refs, err := collectRefs(repo)
+			refs, err := collectRefs(repo, nil)
 
 			if tt.expectErr {
 				require.Error(t, err)
@@ -178,7 +183,7 @@ func TestCollectRefs(t *testing.T) {
 func TestCollectRefs_Error(t *testing.T) {
 	repo := &mockGitRepository{err: fmt.Errorf("failed to get references")}
 
-	refs, err := collectRefs(repo)
+	refs, err := collectRefs(repo, nil)
 
 	require.Error(t, err)
 	assert.Nil(t, refs)
@@ -259,7 +264,7 @@ func TestPushRefsInBatches(t *testing.T) {
 				pushError:       tt.pushError,
 			}
 
-			err := pushRefsInBatches(context.Background(), remote, tt.refs, tt.batchSize, nil, "https://example.com/repo.git")
+			err := pushRefsInBatches(context.Background(), remote, tt.refs, tt.batchSize, endpoint{}, "https://example.com/repo.git")
 
 			if tt.expectErr {
 				require.Error(t, err)
@@ -281,7 +286,7 @@ func TestPushRefsInBatches_RefSpecFormat(t *testing.T) {
 
 	remote := &mockGitRemote{}
 
-	err := pushRefsInBatches(context.Background(), remote, refs, 10, nil, "https://example.com/repo.git")
+	err := pushRefsInBatches(context.Background(), remote, refs, 10, endpoint{}, "https://example.com/repo.git")
 
 	require.NoError(t, err)
 	require.Len(t, remote.pushCalls, 1)
@@ -299,7 +304,7 @@ func TestPushRefsInBatches_BatchSizes(t *testing.T) {
 
 	remote := &mockGitRemote{}
 
-	err := pushRefsInBatches(context.Background(), remote, refs, batchSize, nil, "https://example.com/repo.git")
+	err := pushRefsInBatches(context.Background(), remote, refs, batchSize, endpoint{}, "https://example.com/repo.git")
 
 	require.NoError(t, err)
 	require.Len(t, remote.pushCalls, 3)
@@ -353,7 +358,7 @@ func TestPushOnlyFlags_GitHubAppAuth_RejectsImpersonation(t *testing.T) {
 
 func TestResolveCreateOrgName_GitHubApp(t *testing.T) {
 	// With App auth the user API must never be called, so a nil client is safe.
-	orgName, isAE, aeDetermined, err := resolveCreateOrgName(context.Background(), nil, "my-org", true)
+	orgName, isAE, aeDetermined, err := resolveCreateOrgName(context.Background(), nil, "my-org", true, io.Discard)
 
 	require.NoError(t, err)
 	assert.Equal(t, "my-org", orgName)
@@ -377,7 +382,7 @@ func TestResolveCreateOrgName_PAT_OwnerIsAuthenticatedUser(t *testing.T) {
 
 	client := newTestGitHubClient(t, server.URL)
 
-	orgName, _, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "monalisa", false)
+	orgName, _, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "monalisa", false, io.Discard)
 
 	require.NoError(t, err)
 	assert.Equal(t, "", orgName, "repo should be created under the authenticated user's account")
@@ -406,7 +411,7 @@ func TestResolveCreateOrgName_PAT_OwnerIsOrg(t *testing.T) {
 
 	client := newTestGitHubClient(t, server.URL)
 
-	orgName, _, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "my-org", false)
+	orgName, _, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "my-org", false, io.Discard)
 
 	require.NoError(t, err)
 	assert.Equal(t, "my-org", orgName)
@@ -430,7 +435,7 @@ func TestResolveCreateOrgName_PAT_GitHubAE(t *testing.T) {
 
 	client := newTestGitHubClient(t, server.URL)
 
-	orgName, isAE, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "monalisa", false)
+	orgName, isAE, aeDetermined, err := resolveCreateOrgName(context.Background(), client, "monalisa", false, io.Discard)
 
 	require.NoError(t, err)
 	assert.Equal(t, "", orgName)
@@ -452,7 +457,7 @@ func TestResolveCreateOrgName_PAT_NilLogin(t *testing.T) {
 
 	client := newTestGitHubClient(t, server.URL)
 
-	orgName, _, _, err := resolveCreateOrgName(context.Background(), client, "my-org", false)
+	orgName, _, _, err := resolveCreateOrgName(context.Background(), client, "my-org", false, io.Discard)
 
 	require.Error(t, err)
 	assert.Equal(t, "", orgName)
@@ -469,7 +474,7 @@ func TestResolveCreateOrgName_PAT_UserError(t *testing.T) {
 
 	client := newTestGitHubClient(t, server.URL)
 
-	orgName, _, _, err := resolveCreateOrgName(context.Background(), client, "my-org", false)
+	orgName, _, _, err := resolveCreateOrgName(context.Background(), client, "my-org", false, io.Discard)
 
 	require.Error(t, err)
 	assert.Equal(t, "", orgName)
@@ -482,11 +487,13 @@ func TestGetOrCreateGitHubRepo_GitHubApp_CreatesUnderOrg(t *testing.T) {
 	f := &fakeGitHub{repoExists: false}
 	client := f.start(t)
 
-	repo, err := getOrCreateGitHubRepo(context.Background(), client, "my-repo", "my-org", true)
+	var out bytes.Buffer
+	repo, err := getOrCreateGitHubRepo(context.Background(), client, "my-repo", "my-org", true, "", &out)
 
 	require.NoError(t, err)
 	require.NotNil(t, repo)
 	assert.Equal(t, "my-repo", repo.GetName())
+	assert.Equal(t, "Created repo `my-org/my-repo`\n", out.String(), "output goes to the repository's writer")
 	assert.False(t, f.userCalled, "App auth must not call the user API")
 	assert.True(t, f.created, "missing repo should be created")
 	assert.False(t, f.createdUnderUser, "App auth must create under the org, not a user account")
@@ -498,7 +505,7 @@ func TestGetOrCreateGitHubRepo_GitHubApp_ExistingRepo(t *testing.T) {
 	f := &fakeGitHub{repoExists: true}
 	client := f.start(t)
 
-	repo, err := getOrCreateGitHubRepo(context.Background(), client, "existing-repo", "my-org", true)
+	repo, err := getOrCreateGitHubRepo(context.Background(), client, "existing-repo", "my-org", true, "", io.Discard)
 
 	require.NoError(t, err)
 	require.NotNil(t, repo)
@@ -512,7 +519,7 @@ func TestGetOrCreateGitHubRepo_GitHubApp_GHAE_InternalVisibility(t *testing.T) {
 	f := &fakeGitHub{repoExists: false, repoGetAE: true}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "ghae-repo", "my-org", true)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "ghae-repo", "my-org", true, "", io.Discard)
 
 	require.NoError(t, err)
 	assert.False(t, f.userCalled, "App auth must not call the user API")
@@ -528,7 +535,7 @@ func TestGetOrCreateGitHubRepo_PAT_ExistingRepo(t *testing.T) {
 	f := &fakeGitHub{repoExists: true, userLogin: "monalisa"}
 	client := f.start(t)
 
-	repo, err := getOrCreateGitHubRepo(context.Background(), client, "existing-repo", "monalisa", false)
+	repo, err := getOrCreateGitHubRepo(context.Background(), client, "existing-repo", "monalisa", false, "", io.Discard)
 
 	require.NoError(t, err)
 	require.NotNil(t, repo)
@@ -540,7 +547,7 @@ func TestGetOrCreateGitHubRepo_PAT_CreatesUnderUser(t *testing.T) {
 	f := &fakeGitHub{repoExists: false, userLogin: "monalisa"}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "monalisa", false)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "monalisa", false, "", io.Discard)
 
 	require.NoError(t, err)
 	assert.True(t, f.created)
@@ -553,7 +560,7 @@ func TestGetOrCreateGitHubRepo_PAT_CreatesUnderOrg(t *testing.T) {
 	f := &fakeGitHub{repoExists: false, userLogin: "monalisa"}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "my-org", false)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "my-org", false, "", io.Discard)
 
 	require.NoError(t, err)
 	assert.True(t, f.createOrgCalled, "owner differing from the authenticated user must ensure the org exists")
@@ -568,7 +575,7 @@ func TestGetOrCreateGitHubRepo_PAT_GHAE_InternalVisibility(t *testing.T) {
 	f := &fakeGitHub{repoExists: false, userLogin: "monalisa", userAE: true}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "ghae-repo", "monalisa", false)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "ghae-repo", "monalisa", false, "", io.Discard)
 
 	require.NoError(t, err)
 	assert.True(t, f.created)
@@ -580,7 +587,7 @@ func TestGetOrCreateGitHubRepo_PAT_RepoGetError(t *testing.T) {
 	f := &fakeGitHub{repoGetStatus: http.StatusInternalServerError, userLogin: "monalisa"}
 	client := f.start(t)
 
-	repo, err := getOrCreateGitHubRepo(context.Background(), client, "some-repo", "monalisa", false)
+	repo, err := getOrCreateGitHubRepo(context.Background(), client, "some-repo", "monalisa", false, "", io.Discard)
 
 	require.Error(t, err)
 	assert.Nil(t, repo)
@@ -592,7 +599,7 @@ func TestGetOrCreateGitHubRepo_GitHubApp_UserNeverCalledOnError(t *testing.T) {
 	f := &fakeGitHub{repoGetStatus: http.StatusInternalServerError}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "some-repo", "my-org", true)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "some-repo", "my-org", true, "", io.Discard)
 
 	require.Error(t, err)
 	assert.False(t, f.userCalled, "App auth must not call the user API even on error paths")
@@ -603,7 +610,7 @@ func TestGetOrCreateGitHubRepo_CreateFailureIsWrapped(t *testing.T) {
 	f := &fakeGitHub{repoExists: false, createRepoStatus: http.StatusUnprocessableEntity}
 	client := f.start(t)
 
-	repo, err := getOrCreateGitHubRepo(context.Background(), client, "bad-repo", "my-org", true)
+	repo, err := getOrCreateGitHubRepo(context.Background(), client, "bad-repo", "my-org", true, "", io.Discard)
 
 	require.Error(t, err)
 	assert.Nil(t, repo)
@@ -621,7 +628,7 @@ func TestGetOrCreateGitHubRepo_PAT_OrgAlreadyExistsFallback(t *testing.T) {
 	}
 	client := f.start(t)
 
-	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "org-already-exists", false)
+	_, err := getOrCreateGitHubRepo(context.Background(), client, "new-repo", "org-already-exists", false, "", io.Discard)
 
 	require.NoError(t, err)
 	assert.True(t, f.createOrgCalled, "org creation should be attempted")
@@ -629,3 +636,140 @@ func TestGetOrCreateGitHubRepo_PAT_OrgAlreadyExistsFallback(t *testing.T) {
 	assert.True(t, f.created)
 	assert.Equal(t, "org-already-exists", f.createdOrg)
 }
+
+func TestPushOnlyFlags_Validate_NegativePushConcurrencyRejected(t *testing.T) {
+	flags := PushOnlyFlags{
+		BaseURL:         "https://example.com",
+		Token:           "token",
+		PushConcurrency: -1,
+	}
+
+	validations := flags.Validate()
+
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--push-concurrency")
+}
+
+func TestPushOnlyFlags_Validate_FromBundleRejectsPushConcurrency(t *testing.T) {
+	flags := PushOnlyFlags{
+		BaseURL:         "https://example.com",
+		Token:           "token",
+		FromBundle:      "cache.tar",
+		PushConcurrency: 4,
+	}
+	validations := flags.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--push-concurrency cannot be used with --from-bundle")
+
+	flags.PushConcurrency = 1
+	assert.Empty(t, flags.Validate())
+}
+
+func TestResolveCreateOrgName_ConcurrentPushesCreateOrgOnce(t *testing.T) {
+	// Several repositories in the same new org pushed at once must only try to
+	// create the org a single time.
+	var createOrgCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v3/user":
+			b, _ := json.Marshal(github.User{Login: github.String("monalisa")})
+			_, _ = w.Write(b)
+		case r.URL.Path == "/api/v3/admin/organizations" && r.Method == http.MethodPost:
+			if atomic.AddInt32(&createOrgCalls, 1) > 1 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"message":"Organization already exists"}`))
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			b, _ := json.Marshal(github.Organization{Login: github.String("new-org")})
+			_, _ = w.Write(b)
+		default:
+			t.Errorf("unexpected request to %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestGitHubClient(t, server.URL)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, _, errs[i] = resolveCreateOrgName(context.Background(), client, "new-org", false, io.Discard)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&createOrgCalls), "org should only be created once")
+}
+
+func TestCollectRefs_RefFilter(t *testing.T) {
+	repo := &mockGitRepository{refs: []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.NewHash("abc123")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("dependabot/npm/foo"), plumbing.NewHash("def456")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v1.0.0"), plumbing.NewHash("ghi789")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("nightly"), plumbing.NewHash("jkl012")),
+	}}
+	selection, err := newRefSelection(&CommonFlags{
+		IncludeRefs: []string{"refs/tags/v*", "refs/heads/*"},
+		ExcludeRefs: []string{"refs/heads/dependabot/*"},
+	})
+	require.NoError(t, err)
+
+	refs, err := collectRefs(repo, selection)
+
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName("main"),
+		plumbing.NewTagReferenceName("v1.0.0"),
+	}, refs)
+}
+
+func TestCollectRefs_SemverSelectionPeelsAnnotatedTags(t *testing.T) {
+	repo := &mockGitRepository{
+		refs: []*plumbing.Reference{
+			plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.NewHash("c2")),
+			plumbing.NewHashReference(plumbing.NewTagReferenceName("v3.6.0"), plumbing.NewHash("b2")),
+			plumbing.NewHashReference(plumbing.NewTagReferenceName("v3"), plumbing.NewHash("b2")),
+			plumbing.NewHashReference(plumbing.NewTagReferenceName("v4.1.0"), plumbing.NewHash("ff")),
+			plumbing.NewHashReference(plumbing.NewTagReferenceName("v4"), plumbing.NewHash("c2")),
+		},
+		tagTargets: map[plumbing.Hash]plumbing.Hash{plumbing.NewHash("ff"): plumbing.NewHash("c2")},
+	}
+	selection, err := newRefSelection(&CommonFlags{TagsSemver: ">=4"})
+	require.NoError(t, err)
+
+	refs, err := collectRefs(repo, selection)
+
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName("main"),
+		plumbing.NewTagReferenceName("v4.1.0"),
+		plumbing.NewTagReferenceName("v4"),
+	}, refs)
+}
+
+func TestCollectRefs_PinnedRefs(t *testing.T) {
+	repo := &mockGitRepository{refs: []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.NewHash("abc123")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v4"), plumbing.NewHash("def456")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v3.6.0"), plumbing.NewHash("ghi789")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v3.5.0"), plumbing.NewHash("jkl012")),
+	}}
+	selection, err := newRepoRefSelection(&CommonFlags{KeepLatestMajors: 1}, []string{"v4", "v3.6.0"})
+	require.NoError(t, err)
+
+	refs, err := collectRefs(repo, selection)
+
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName("v4"),
+		plumbing.NewTagReferenceName("v3.6.0"),
+	}, refs)
+}
@@ -0,0 +1,118 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+// pushReceipt lists the branches and tags each repository has on the
+// destination after a push. Given the receipt, pull exports only what changed
+// since, as the destination already has everything the receipt lists.
+type pushReceipt struct {
+	Version      int                     `json:"version"`
+	Destination  string                  `json:"destination"`
+	Repositories map[string]*receiptRepo `json:"repositories"`
+
+	// mu guards Repositories while repositories are pushed concurrently
+	mu sync.Mutex
+}
+
+// receiptRepo maps every branch and tag of a repository on the destination to
+// the hash it points at.
+type receiptRepo struct {
+	Refs map[string]string `json:"refs"`
+}
+
+// readPushReceipt reads a receipt written by push.
+func readPushReceipt(file string) (*pushReceipt, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading push receipt `%s`", file)
+	}
+	r := &pushReceipt{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, errors.Wrapf(err, "error parsing push receipt `%s`", file)
+	}
+	if r.Repositories == nil {
+		r.Repositories = map[string]*receiptRepo{}
+	}
+	// the names are looked up as paths in the cache, so like those in a bundle
+	// index they can't be trusted to stay inside it
+	for nwo := range r.Repositories {
+		if !NestedNwoRegExp.MatchString(nwo) || hasDotSegment(nwo) {
+			return nil, errors.Errorf("push receipt `%s` lists `%s`, which is not a valid repo name", file, nwo)
+		}
+	}
+	return r, nil
+}
+
+// loadPushReceipt returns the receipt for a push to destination to record its
+// repositories in. A receipt already in file for the same destination is added
+// to, so pushing some of the repositories keeps the others. A receipt for a
+// different destination is an error rather than being overwritten.
+func loadPushReceipt(file, destination string) (*pushReceipt, error) {
+	fresh := &pushReceipt{Version: 1, Destination: destination, Repositories: map[string]*receiptRepo{}}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return fresh, nil
+	}
+	r, err := readPushReceipt(file)
+	if err != nil {
+		return nil, err
+	}
+	if r.Destination != destination {
+		return nil, errors.Errorf("push receipt `%s` is for %s, not %s. Write the receipt of each destination to its own file", file, r.Destination, destination)
+	}
+	return r, nil
+}
+
+// repo returns what the receipt lists for nwo, or nil when it doesn't list
+// the repository.
+func (r *pushReceipt) repo(nwo string) *receiptRepo {
+	if r == nil {
+		return nil
+	}
+	return r.Repositories[nwo]
+}
+
+// record records the branches and tags in destRefs, which the destination
+// has after nwo was pushed to it. A nil receipt records nothing.
+func (r *pushReceipt) record(nwo string, destRefs []*plumbing.Reference) {
+	if r == nil {
+		return
+	}
+	recorded := &receiptRepo{Refs: map[string]string{}}
+	for _, ref := range destRefs {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag()) {
+			recorded.Refs[ref.Name().String()] = ref.Hash().String()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Repositories[nwo] = recorded
+}
+
+// write replaces file with the receipt, writing it next to file first so an
+// interrupted push can't leave half of one.
+func (r *pushReceipt) write(file string, out io.Writer) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return errors.Wrapf(err, "error writing push receipt `%s`", tmp)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return errors.Wrapf(err, "error writing push receipt `%s`", file)
+	}
+	fmt.Fprintf(out, "wrote the refs of %d repositories to the push receipt %s\n", len(r.Repositories), file)
+	return nil
+}
@@ -0,0 +1,182 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v43/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReceiptPush returns flags and a client that push the cache to bare
+// repositories under destRoot, standing in for the destination.
+func newTestReceiptPush(t *testing.T, cacheDir, destRoot string, nwos ...string) (*PushFlags, *github.Client) {
+	t.Helper()
+	for _, nwo := range nwos {
+		_, err := git.PlainInit(path.Join(destRoot, nwo), true)
+		require.NoError(t, err)
+	}
+	server := httptest.NewServer((&fakeGitHub{userLogin: "admin", repoExists: true}).handler(t))
+	t.Cleanup(server.Close)
+	flags := &PushFlags{
+		CommonFlags:   CommonFlags{CacheDir: cacheDir},
+		PushOnlyFlags: PushOnlyFlags{BaseURL: server.URL, DisableGitAuth: true, GitURL: "file://" + destRoot},
+	}
+	return flags, newTestGitHubClient(t, server.URL)
+}
+
+func TestPushReceipt_IncrementalBundle(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	dir := path.Join(cacheDir, "actions", "checkout")
+	first := initTestRepository(t, dir)
+	initTestRepository(t, path.Join(cacheDir, "actions", "setup-go"))
+	destRoot := t.TempDir()
+	flags, client := newTestReceiptPush(t, cacheDir, destRoot, "actions/checkout", "actions/setup-go")
+
+	receiptFile := path.Join(t.TempDir(), "receipt.json")
+	var err error
+	flags.receipt, err = loadPushReceipt(receiptFile, flags.BaseURL)
+	require.NoError(t, err)
+	require.NoError(t, PushManyWithGitImpl(ctx, flags, []string{"actions/checkout", "actions/setup-go"}, client, gitImplementation{}))
+	require.NoError(t, flags.receipt.write(receiptFile, io.Discard))
+
+	receipt, err := readPushReceipt(receiptFile)
+	require.NoError(t, err)
+	assert.Equal(t, flags.BaseURL, receipt.Destination)
+	assert.Equal(t, map[string]string{
+		"refs/heads/main":  first.String(),
+		"refs/tags/v1.0.0": first.String(),
+	}, receipt.repo("actions/checkout").Refs)
+
+	// upstream moves on: a new commit and tag, and the old tag is deleted
+	second := commitTestFile(t, dir, "name: changed\n", time.Now())
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v1.1.0", second, nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteTag("v1.0.0"))
+
+	file := path.Join(t.TempDir(), "delta.tar")
+	var out bytes.Buffer
+	require.NoError(t, exportBundleArchive(cacheDir, file, receipt, &out))
+	assert.Contains(t, out.String(), "`actions/setup-go` hasn't changed since the push receipt")
+
+	archive, err := openBundleArchive(file)
+	require.NoError(t, err)
+	require.Len(t, archive.index.Repositories, 1)
+	entry := archive.index.Repositories[0]
+	assert.True(t, entry.Incremental)
+	assert.Equal(t, []string{"refs/tags/v1.0.0"}, entry.Deleted)
+	_, r, err := archive.next("actions/checkout")
+	require.NoError(t, err)
+	header, err := readBundleHeader(bufio.NewReader(r))
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{first}, header.prerequisites)
+	var names []string
+	for _, ref := range header.refs {
+		names = append(names, ref.Name().String())
+	}
+	assert.Equal(t, []string{"refs/heads/main", "refs/tags/v1.1.0", "HEAD"}, names, "unchanged refs are left out")
+	archive.Close()
+
+	archive, err = openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+	flags.CacheDir = t.TempDir()
+	flags.Prune = true
+	flags.PruneThreshold = 50
+	require.NoError(t, pushBundleArchive(ctx, flags, archive, []string{"actions/checkout"}, client, gitImplementation{}))
+
+	dest, err := git.PlainOpen(path.Join(destRoot, "actions", "checkout"))
+	require.NoError(t, err)
+	ref, err := dest.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	assert.Equal(t, second, ref.Hash())
+	_, err = dest.Reference(plumbing.NewTagReferenceName("v1.1.0"), false)
+	assert.NoError(t, err)
+	_, err = dest.Reference(plumbing.NewTagReferenceName("v1.0.0"), false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound, "deleted refs are pruned")
+}
+
+func TestPushBundleArchive_IncrementalNeedsBase(t *testing.T) {
+	cacheDir := t.TempDir()
+	dir := path.Join(cacheDir, "actions", "checkout")
+	first := initTestRepository(t, dir)
+	commitTestFile(t, dir, "name: changed\n", time.Now())
+	receipt := &pushReceipt{Repositories: map[string]*receiptRepo{
+		"actions/checkout": {Refs: map[string]string{"refs/heads/main": first.String()}},
+	}}
+	file := path.Join(t.TempDir(), "delta.tar")
+	require.NoError(t, exportBundleArchive(cacheDir, file, receipt, io.Discard))
+
+	// the destination was never pushed to, so it lacks the base commit
+	flags, client := newTestReceiptPush(t, t.TempDir(), t.TempDir(), "actions/checkout")
+	archive, err := openBundleArchive(file)
+	require.NoError(t, err)
+	defer archive.Close()
+	err = pushBundleArchive(context.Background(), flags, archive, []string{"actions/checkout"}, client, gitImplementation{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't have commit "+first.String())
+}
+
+func TestLoadPushReceipt(t *testing.T) {
+	file := path.Join(t.TempDir(), "receipt.json")
+	r, err := loadPushReceipt(file, "https://ghes.example.com")
+	require.NoError(t, err)
+	r.Repositories["actions/checkout"] = &receiptRepo{Refs: map[string]string{"refs/heads/main": "abc"}}
+	require.NoError(t, r.write(file, io.Discard))
+
+	r, err = loadPushReceipt(file, "https://ghes.example.com")
+	require.NoError(t, err)
+	assert.Contains(t, r.Repositories, "actions/checkout", "a push to the same destination adds to the receipt")
+
+	_, err = loadPushReceipt(file, "https://other.example.com")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is for https://ghes.example.com, not https://other.example.com")
+
+	_, err = readPushReceipt(path.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestReadPushReceipt_InvalidRepoName(t *testing.T) {
+	for _, nwo := range []string{"../escape", "actions/..", "actions/../../escape"} {
+		file := writeTestManifest(t, "receipt.json", `{"version": 1, "destination": "https://ghes.example.com", "repositories": {"`+nwo+`": {"refs": {}}}}`)
+		_, err := readPushReceipt(file)
+		require.Error(t, err, nwo)
+		assert.Contains(t, err.Error(), "not a valid repo name", nwo)
+	}
+}
+
+func TestPushBundleArchive_IncrementalMaliciousIndex(t *testing.T) {
+	parent := t.TempDir()
+	cacheDir := path.Join(parent, "cache")
+	archive, err := openBundleArchive(writeTestBundleArchive(t, &bundleIndexEntry{Repo: "../escape", Incremental: true}, []byte("bundle")))
+	require.NoError(t, err)
+	defer archive.Close()
+
+	// the destination isn't fetched into a directory outside the import one
+	flags := &PushFlags{CommonFlags: CommonFlags{CacheDir: cacheDir}}
+	err = pushBundleArchive(context.Background(), flags, archive, []string{"../escape"}, nil, gitImplementation{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a valid repo name")
+	_, err = os.Stat(path.Join(parent, "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPullOnlyFlags_Validate_SinceReceipt(t *testing.T) {
+	f := &PullOnlyFlags{SourceURL: "https://github.com", SinceReceipt: "receipt.json"}
+	assert.Contains(t, f.Validate(), "--since-receipt requires --export-bundle")
+	f.ExportBundle = "delta.tar"
+	assert.Empty(t, f.Validate())
+}
@@ -0,0 +1,91 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// refFilter selects refs by glob patterns matched against the full ref name,
+// e.g. `refs/tags/v*` or `refs/heads/main`. `*` matches any sequence of
+// characters including `/` (as in git refspecs) and `?` matches a single
+// character. A ref is selected when it matches at least one include pattern (or
+// there are none) and no exclude pattern. Include patterns prefixed with `!` are
+// treated as exclude patterns.
+type refFilter struct {
+	include, exclude []*regexp.Regexp
+}
+
+func newRefFilter(include, exclude []string) (*refFilter, error) {
+	f := &refFilter{}
+	for _, pattern := range include {
+		negate := strings.HasPrefix(pattern, "!")
+		re, err := compileRefPattern(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			f.exclude = append(f.exclude, re)
+		} else {
+			f.include = append(f.include, re)
+		}
+	}
+	for _, pattern := range exclude {
+		re, err := compileRefPattern(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+func compileRefPattern(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	if !strings.HasPrefix(pattern, "refs/") {
+		return nil, fmt.Errorf("`%s` is not a valid ref pattern, patterns must match full ref names such as `refs/tags/v*` or `refs/heads/main`", pattern)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// IsEmpty reports whether the filter selects every ref.
+func (f *refFilter) IsEmpty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0)
+}
+
+// Match reports whether the ref is selected by the filter.
+func (f *refFilter) Match(name plumbing.ReferenceName) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name.String()) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name.String()) {
+			return true
+		}
+	}
+	return false
+}
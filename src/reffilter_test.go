@@ -0,0 +1,97 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefFilter_Match(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		selected []string
+		rejected []string
+	}{
+		{
+			name:     "empty filter selects everything",
+			selected: []string{"refs/heads/main", "refs/tags/v1.0.0", "refs/heads/dependabot/npm/foo"},
+		},
+		{
+			name:     "include tags and main",
+			include:  []string{"refs/tags/v*", "refs/heads/main"},
+			selected: []string{"refs/heads/main", "refs/tags/v1.0.0", "refs/tags/v4"},
+			rejected: []string{"refs/heads/develop", "refs/tags/release-1", "refs/heads/main-old"},
+		},
+		{
+			name:     "star crosses slashes",
+			exclude:  []string{"refs/heads/dependabot/*"},
+			selected: []string{"refs/heads/main", "refs/tags/v1"},
+			rejected: []string{"refs/heads/dependabot/npm_and_yarn/lodash-4.17.21"},
+		},
+		{
+			name:     "negated include pattern excludes",
+			include:  []string{"refs/heads/*", "!refs/heads/dependabot/*"},
+			selected: []string{"refs/heads/main", "refs/heads/releases/v1"},
+			rejected: []string{"refs/heads/dependabot/github_actions/foo", "refs/tags/v1"},
+		},
+		{
+			name:     "question mark matches a single character",
+			include:  []string{"refs/tags/v?"},
+			selected: []string{"refs/tags/v1", "refs/tags/v4"},
+			rejected: []string{"refs/tags/v10", "refs/tags/v1.0.0"},
+		},
+		{
+			name:     "regexp metacharacters are literal",
+			include:  []string{"refs/tags/v1.0.0"},
+			selected: []string{"refs/tags/v1.0.0"},
+			rejected: []string{"refs/tags/v1x0x0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newRefFilter(tt.include, tt.exclude)
+			require.NoError(t, err)
+			for _, name := range tt.selected {
+				assert.True(t, filter.Match(plumbing.ReferenceName(name)), "%s should be selected", name)
+			}
+			for _, name := range tt.rejected {
+				assert.False(t, filter.Match(plumbing.ReferenceName(name)), "%s should be rejected", name)
+			}
+		})
+	}
+}
+
+func TestRefFilter_IsEmpty(t *testing.T) {
+	var nilFilter *refFilter
+	assert.True(t, nilFilter.IsEmpty())
+	assert.True(t, nilFilter.Match("refs/heads/main"), "a nil filter selects everything")
+
+	filter, err := newRefFilter(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, filter.IsEmpty())
+
+	filter, err = newRefFilter(nil, []string{"refs/heads/*"})
+	require.NoError(t, err)
+	assert.False(t, filter.IsEmpty())
+}
+
+func TestRefFilter_RejectsPatternsWithoutRefsPrefix(t *testing.T) {
+	_, err := newRefFilter([]string{"v*"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "`v*` is not a valid ref pattern")
+
+	_, err = newRefFilter(nil, []string{"!heads/dependabot/*"})
+	require.Error(t, err)
+}
+
+func TestCommonFlags_Validate_InvalidRefPattern(t *testing.T) {
+	f := &CommonFlags{RepoName: "actions/checkout", IncludeRefs: []string{"tags/v*"}}
+	validations := f.Validate(true)
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "not a valid ref pattern")
+}
@@ -0,0 +1,107 @@
+package src
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// refSelection decides which branches and tags are synced. It combines the
+// --include-refs/--exclude-refs glob filters with semver-aware tag selection.
+// Refs pinned on a repo list entry replace both: only the pinned branches and
+// tags are selected.
+type refSelection struct {
+	filter *refFilter
+	semver *semverSelection
+	pins   map[string]bool
+}
+
+func newRefSelection(flags *CommonFlags) (*refSelection, error) {
+	filter, err := newRefFilter(flags.IncludeRefs, flags.ExcludeRefs)
+	if err != nil {
+		return nil, err
+	}
+	semverSelection, err := newSemverSelection(flags.TagsSemver, flags.KeepLatestMajors, flags.SkipPrerelease)
+	if err != nil {
+		return nil, err
+	}
+	return &refSelection{filter: filter, semver: semverSelection}, nil
+}
+
+// newRepoRefSelection returns the selection for a single repository, which is
+// limited to the pinned refs when there are any.
+func newRepoRefSelection(flags *CommonFlags, pins []string) (*refSelection, error) {
+	if len(pins) == 0 {
+		return newRefSelection(flags)
+	}
+	s := &refSelection{pins: map[string]bool{}}
+	for _, pin := range pins {
+		s.pins[pin] = true
+	}
+	return s, nil
+}
+
+// IsEmpty reports whether every branch and tag is selected.
+func (s *refSelection) IsEmpty() bool {
+	return s == nil || (s.filter.IsEmpty() && s.semver.IsEmpty() && !s.HasPins())
+}
+
+// HasPins reports whether the selection is limited to pinned refs.
+func (s *refSelection) HasPins() bool {
+	return s != nil && len(s.pins) > 0
+}
+
+// IsPinned reports whether the ref is one of the pinned branches or tags.
+func (s *refSelection) IsPinned(name plumbing.ReferenceName) bool {
+	return s.HasPins() && (name.IsBranch() || name.IsTag()) && s.pins[name.Short()]
+}
+
+// NeedsTagTargets reports whether Select needs to know the commit each tag
+// points at.
+func (s *refSelection) NeedsTagTargets() bool {
+	return s != nil && !s.HasPins() && !s.semver.IsEmpty()
+}
+
+// Select returns the names of the branches and tags in refs that should be
+// synced, in the order given. targets maps annotated tags to the commit they
+// point at; tags missing from targets are assumed to point at a commit
+// directly.
+func (s *refSelection) Select(refs []*plumbing.Reference, targets map[plumbing.ReferenceName]plumbing.Hash) []plumbing.ReferenceName {
+	var candidates []*plumbing.Reference
+	for _, ref := range refs {
+		name := ref.Name()
+		if !name.IsBranch() && !name.IsTag() {
+			continue
+		}
+		if s.HasPins() {
+			if !s.IsPinned(name) {
+				continue
+			}
+		} else if s != nil && !s.filter.Match(name) {
+			continue
+		}
+		candidates = append(candidates, ref)
+	}
+
+	var dropped map[plumbing.ReferenceName]bool
+	if s.NeedsTagTargets() {
+		tags := map[plumbing.ReferenceName]plumbing.Hash{}
+		for _, ref := range candidates {
+			if !ref.Name().IsTag() {
+				continue
+			}
+			if target, ok := targets[ref.Name()]; ok {
+				tags[ref.Name()] = target
+			} else {
+				tags[ref.Name()] = ref.Hash()
+			}
+		}
+		dropped = s.semver.Select(tags)
+	}
+
+	var selected []plumbing.ReferenceName
+	for _, ref := range candidates {
+		if !dropped[ref.Name()] {
+			selected = append(selected, ref.Name())
+		}
+	}
+	return selected
+}
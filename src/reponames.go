@@ -7,11 +7,13 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/pkg/errors"
 )
 
 var (
 	NwoRegExp        = regexp.MustCompile(`^[^/\s]+/[^/\s]+$`)
+	NestedNwoRegExp  = regexp.MustCompile(`^[^/\s]+(/[^/\s]+)+$`)
 	ErrEmptyRepoList = errors.New("repo list cannot be empty")
 	ErrEmptyCacheDir = errors.New("cache directory contains no actions to sync")
 )
@@ -21,6 +23,15 @@ func getRepoNamesFromRepoFlags(flags *CommonFlags) ([]string, error) {
 		return getRepoNamesFromCSVString(flags.RepoNameList)
 	}
 
+	if flags.RepoNameListFile != "" && isManifestFile(flags.RepoNameListFile) {
+		m, err := loadManifest(flags.RepoNameListFile)
+		if err != nil {
+			return nil, err
+		}
+		flags.manifest = m
+		return m.repoNames(), nil
+	}
+
 	if flags.RepoNameListFile != "" {
 		return getRepoNamesFromFile(flags.RepoNameListFile)
 	}
@@ -32,6 +43,9 @@ func getRepoNamesFromRepoFlags(flags *CommonFlags) ([]string, error) {
 	return nil, nil
 }
 
+// getRepoNamesFromCacheDir lists the `owner/repo` directories in the cache.
+// Repositories may be stored either bare or with a working tree; both are
+// opened the same way, so the layout doesn't matter here.
 func getRepoNamesFromCacheDir(flags *CommonFlags) ([]string, error) {
 	repoNames := make([]string, 0)
 
@@ -40,18 +54,20 @@ func getRepoNamesFromCacheDir(flags *CommonFlags) ([]string, error) {
 		return nil, errors.Wrapf(err, "error opening cache directory `%s`", flags.CacheDir)
 	}
 	for _, orgDir := range orgDirs {
+		// owners can't start with a dot, so these are files such as the
+		// integrity manifest rather than repositories
+		if strings.HasPrefix(orgDir.Name(), ".") {
+			continue
+		}
 		orgDirPath := path.Join(flags.CacheDir, orgDir.Name())
 		if !orgDir.IsDir() {
 			return nil, errors.Errorf("unexpected file in root of cache directory `%s`", orgDirPath)
 		}
-		repoDirs, err := os.ReadDir(orgDirPath)
+		nested, err := getRepoNamesFromNamespaceDir(orgDirPath, orgDir.Name())
 		if err != nil {
-			return nil, errors.Wrapf(err, "error opening repository cache directory `%s`", orgDirPath)
-		}
-		for _, repoDir := range repoDirs {
-			nwo := fmt.Sprintf("%s/%s", orgDir.Name(), repoDir.Name())
-			repoNames = append(repoNames, nwo)
+			return nil, err
 		}
+		repoNames = append(repoNames, nested...)
 	}
 
 	if len(repoNames) == 0 {
@@ -61,14 +77,71 @@ func getRepoNamesFromCacheDir(flags *CommonFlags) ([]string, error) {
 	return repoNames, nil
 }
 
+// getRepoNamesFromNamespaceDir lists the repositories cached in dir, the
+// directory of the namespace named namespace. Directories that hold neither a
+// bare repository nor a `.git` directory are nested namespaces, such as GitLab
+// subgroups, and are listed in turn.
+func getRepoNamesFromNamespaceDir(dir, namespace string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening repository cache directory `%s`", dir)
+	}
+	repoNames := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || isBareConversionLeftover(entry.Name()) {
+			continue
+		}
+		nwo := fmt.Sprintf("%s/%s", namespace, entry.Name())
+		entryPath := path.Join(dir, entry.Name())
+		if isCachedRepository(entryPath) {
+			repoNames = append(repoNames, nwo)
+			continue
+		}
+		nested, err := getRepoNamesFromNamespaceDir(entryPath, nwo)
+		if err != nil {
+			return nil, err
+		}
+		repoNames = append(repoNames, nested...)
+	}
+	return repoNames, nil
+}
+
 func getRepoNamesFromCSVString(csv string) ([]string, error) {
-	repos := filterEntries(strings.Split(csv, ","))
+	repos := filterEntries(joinPinnedRefs(strings.Split(csv, ",")))
 	if len(repos) == 0 {
 		return nil, ErrEmptyRepoList
 	}
 	return repos, nil
 }
 
+// joinPinnedRefs glues comma separated pinned refs back onto their entry, so
+// `actions/checkout@v4,v3.6.0:myorg/checkout,actions/setup-go` splits into two
+// entries rather than three. A part continues the previous entry when that
+// entry pins refs and the part has no `/` or `@` before its destination.
+func joinPinnedRefs(parts []string) []string {
+	joined := []string{}
+	for _, part := range parts {
+		if n := len(joined); n > 0 && hasPinnedRefs(joined[n-1]) {
+			source := strings.SplitN(strings.SplitN(part, "=>", 2)[0], ":", 2)[0]
+			if source != "" && !strings.ContainsAny(source, "/@") {
+				joined[n-1] += "," + part
+				continue
+			}
+		}
+		joined = append(joined, part)
+	}
+	return joined
+}
+
+// hasPinnedRefs reports whether a repo list entry pins refs, telling the `@`
+// before pinned refs apart from the one in URLs such as `ssh://git@host/repo`.
+func hasPinnedRefs(entry string) bool {
+	if source, _, found := strings.Cut(entry, "=>"); found {
+		return strings.Contains(source[urlPathStart(source):], "@")
+	}
+	return strings.Contains(entry, "@")
+}
+
 func getRepoNamesFromFile(file string) ([]string, error) {
 	data, err := os.ReadFile(file)
 	if err != nil {
@@ -91,36 +164,178 @@ func filterEntries(names []string) []string {
 	return filtered
 }
 
+// repoSpec is a parsed repo list entry of the form
+// `owner/repo[@ref[,ref...]][:dest_owner/dest_repo]`, or
+// `url[@ref[,ref...]]=>dest_owner/dest_repo` for repositories outside
+// --source-url. The origin may be a nested path such as `group/sub/repo`.
+type repoSpec struct {
+	// dest names the repository in the cache. It is the origin for nested
+	// paths without a destination, which destinationNwo maps to an
+	// `owner/repo` name on the destination.
+	origin, dest string
+	// refs pins the branches or tags to sync; empty means every ref.
+	refs []string
+	// url is the explicit git URL of the origin, if any; origin is then the
+	// URL too.
+	url string
+}
+
+// gitURL returns the URL to clone the origin from.
+func (s *repoSpec) gitURL(sourceURL string) string {
+	if s.url != "" {
+		return s.url
+	}
+	return repoGitURL(sourceURL, s.origin)
+}
+
+// entry formats the spec back into a repo list entry.
+func (s *repoSpec) entry() string {
+	source := s.origin
+	if len(s.refs) > 0 {
+		source += "@" + strings.Join(s.refs, ",")
+	}
+	switch {
+	case s.url != "":
+		return source + "=>" + s.dest
+	case s.origin != s.dest:
+		return source + ":" + s.dest
+	}
+	return source
+}
+
 func extractSourceDest(repoName string) (string, string, error) {
+	spec, err := parseRepoSpec(repoName)
+	if err != nil {
+		return "", "", err
+	}
+	return spec.origin, spec.dest, nil
+}
+
+func parseRepoSpec(repoName string) (*repoSpec, error) {
+	if source, dest, found := strings.Cut(repoName, "=>"); found {
+		return parseURLRepoSpec(source, dest)
+	}
+
 	repoNameParts := strings.Split(repoName, ":")
 	if len(repoNameParts) > 2 {
-		return "", "", fmt.Errorf("`%s` is not a valid repo name. Use a single colon to separate source and destination arguments. Example: `upstream_owner/upstream_repo:destination_owner/destination_repo`", repoName)
+		return nil, fmt.Errorf("`%s` is not a valid repo name. Use a single colon to separate source and destination arguments. Example: `upstream_owner/upstream_repo:destination_owner/destination_repo`", repoName)
 	}
 
-	originNwo, err := validateNwo(repoNameParts[0])
+	source, refs, err := splitPinnedRefs(repoNameParts[0])
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	destNwo := originNwo
-	if len(repoNameParts) > 1 {
-		destNwo, err = validateNwo(repoNameParts[1])
+	originNwo := strings.TrimSpace(source)
+	if !NestedNwoRegExp.MatchString(originNwo) || hasDotSegment(originNwo) {
+		return nil, fmt.Errorf("`%s` is not a valid repo name", originNwo)
+	}
+	if len(repoNameParts) == 1 {
+		return &repoSpec{origin: originNwo, dest: originNwo, refs: refs}, nil
+	}
+
+	destNwo, err := validateNwo(repoNameParts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &repoSpec{origin: originNwo, dest: destNwo, refs: refs}, nil
+}
+
+// parseURLRepoSpec parses the two sides of a `url[@refs]=>dest_owner/dest_repo`
+// entry.
+func parseURLRepoSpec(source, dest string) (*repoSpec, error) {
+	source = strings.TrimSpace(source)
+	gitURL, refs := source, []string(nil)
+	if at := strings.LastIndex(source[urlPathStart(source):], "@"); at >= 0 {
+		at += urlPathStart(source)
+		var err error
+		gitURL = source[:at]
+		refs, err = parsePinnedRefs(source[at+1:], source)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
 	}
+	if !strings.Contains(gitURL, "://") && !isSSHURL(gitURL) {
+		return nil, fmt.Errorf("`%s` is not a git URL. Example: `https://gitlab.example.com/group/repo.git=>destination_owner/destination_repo`", gitURL)
+	}
 
-	return originNwo, destNwo, nil
+	destNwo, err := validateNwo(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &repoSpec{origin: gitURL, dest: destNwo, refs: refs, url: gitURL}, nil
+}
+
+// urlPathStart returns the index where the path of a git URL starts, after any
+// `user@` part.
+func urlPathStart(gitURL string) int {
+	if i := strings.Index(gitURL, "://"); i >= 0 {
+		if j := strings.Index(gitURL[i+3:], "/"); j >= 0 {
+			return i + 3 + j
+		}
+		return len(gitURL)
+	}
+	if i := strings.Index(gitURL, ":"); i >= 0 {
+		return i + 1
+	}
+	return 0
+}
+
+// splitPinnedRefs splits `owner/repo@v4,v3.6.0` into the repository and the
+// pinned refs.
+func splitPinnedRefs(source string) (string, []string, error) {
+	nwo, pinned, found := strings.Cut(source, "@")
+	if !found {
+		return source, nil, nil
+	}
+	refs, err := parsePinnedRefs(pinned, source)
+	if err != nil {
+		return "", nil, err
+	}
+	return nwo, refs, nil
+}
+
+// parsePinnedRefs parses the comma separated refs pinned in entry.
+func parsePinnedRefs(pinned, entry string) ([]string, error) {
+	var refs []string
+	for _, ref := range strings.Split(pinned, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || plumbing.NewBranchReferenceName(ref).Validate() != nil {
+			return nil, fmt.Errorf("`%s` is not a valid ref to pin in `%s`. Example: `owner/repo@v4,v3.6.0`", ref, entry)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
 }
 
 func validateNwo(nwo string) (string, error) {
 	s := strings.TrimSpace(nwo)
-	if NwoRegExp.MatchString(s) {
+	if NwoRegExp.MatchString(s) && !hasDotSegment(s) {
 		return s, nil
 	}
 	return "", fmt.Errorf("`%s` is not a valid repo name", s)
 }
 
+// hasDotSegment reports whether nwo has a `.` or `..` segment. Repository
+// names are paths in the cache, so such a name would point outside the
+// directory of its owner.
+func hasDotSegment(nwo string) bool {
+	for _, segment := range strings.Split(nwo, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationNwo returns the `owner/repo` name on the destination of the
+// repository cached as nwo. A nested path keeps its first segment as the owner
+// and joins the rest with dashes, so `group/sub/repo` becomes `group/sub-repo`.
+func destinationNwo(nwo string) string {
+	owner, name, _ := strings.Cut(nwo, "/")
+	return owner + "/" + strings.ReplaceAll(name, "/", "-")
+}
+
 func splitNwo(nwo string) (string, string, error) {
 	nwoParts := strings.Split(nwo, "/")
 	if len(nwoParts) != 2 {
@@ -1,8 +1,13 @@
 package src
 
 import (
+	"context"
+	"io"
+	"path"
 	"testing"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -57,4 +62,168 @@ func Test_validateNwo(t *testing.T) {
 	// A separate destination is only permitted for "repo names", not NWOs.
 	nwo, err = validateNwo("owner/repo:bogus/bogus")
 	require.Error(t, err)
+
+	// names are paths in the cache, so they can't step outside it
+	for _, name := range []string{"../repo", "owner/..", "./repo", "owner/."} {
+		_, err = validateNwo(name)
+		require.Error(t, err, name)
+	}
+	_, err = parseRepoSpec("group/../../repo")
+	require.Error(t, err)
+	_, err = parseRepoSpec("https://gitlab.example.com/group/action.git=>../action")
+	require.Error(t, err)
+}
+
+func Test_parseRepoSpec_PinnedRefs(t *testing.T) {
+	spec, err := parseRepoSpec("actions/checkout@v4,v3.6.0:myorg/checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "actions/checkout", spec.origin)
+	assert.Equal(t, "myorg/checkout", spec.dest)
+	assert.Equal(t, []string{"v4", "v3.6.0"}, spec.refs)
+
+	spec, err = parseRepoSpec("actions/checkout@releases/v1")
+	require.NoError(t, err)
+	assert.Equal(t, "actions/checkout", spec.dest)
+	assert.Equal(t, []string{"releases/v1"}, spec.refs)
+
+	spec, err = parseRepoSpec("actions/checkout")
+	require.NoError(t, err)
+	assert.Empty(t, spec.refs)
+
+	_, err = parseRepoSpec("actions/checkout@")
+	require.Error(t, err)
+
+	_, err = parseRepoSpec("actions/checkout@v4,,v3")
+	require.Error(t, err)
+
+	_, err = parseRepoSpec("actions/checkout@bad..ref")
+	require.Error(t, err)
+
+	// extractSourceDest ignores the pinned refs
+	src, dst, err := extractSourceDest("actions/checkout@v4:myorg/checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "actions/checkout", src)
+	assert.Equal(t, "myorg/checkout", dst)
+}
+
+func Test_getRepoNamesFromCSVString_PinnedRefs(t *testing.T) {
+	repos, err := getRepoNamesFromCSVString("actions/checkout@v4,v3.6.0:myorg/checkout,actions/setup-go,actions/cache@v4,v3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"actions/checkout@v4,v3.6.0:myorg/checkout",
+		"actions/setup-go",
+		"actions/cache@v4,v3",
+	}, repos)
+}
+
+func Test_getRepoNamesFromCacheDir_BothLayouts(t *testing.T) {
+	cacheDir := t.TempDir()
+	initTestRepository(t, path.Join(cacheDir, "actions", "checkout"))
+	bareDir := path.Join(cacheDir, "actions", "setup-go")
+	initTestRepository(t, bareDir)
+	_, err := convertToBare(bareDir)
+	require.NoError(t, err)
+
+	initTestRepository(t, path.Join(cacheDir, "group", "sub", "action"))
+
+	repoNames, err := getRepoNamesFromCacheDir(&CommonFlags{CacheDir: cacheDir})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"actions/checkout", "actions/setup-go", "group/sub/action"}, repoNames)
+}
+
+func Test_parseRepoSpec_SourceURL(t *testing.T) {
+	spec, err := parseRepoSpec("https://gitlab.example.com/group/sub/action.git=>myorg/action")
+	require.NoError(t, err)
+	assert.Equal(t, "https://gitlab.example.com/group/sub/action.git", spec.url)
+	assert.Equal(t, "myorg/action", spec.dest)
+	assert.Empty(t, spec.refs)
+	assert.Equal(t, spec.url, spec.gitURL("https://github.com"))
+
+	spec, err = parseRepoSpec("ssh://git@gitea.example.com/group/action.git@v1,releases/v2=>myorg/action")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh://git@gitea.example.com/group/action.git", spec.url)
+	assert.Equal(t, []string{"v1", "releases/v2"}, spec.refs)
+
+	spec, err = parseRepoSpec("git@gitlab.example.com:group/action.git => myorg/action")
+	require.NoError(t, err)
+	assert.Equal(t, "git@gitlab.example.com:group/action.git", spec.url)
+	assert.Empty(t, spec.refs)
+
+	_, err = parseRepoSpec("gitlab.example.com/group/action=>myorg/action")
+	require.Error(t, err, "the source must be a URL")
+	_, err = parseRepoSpec("https://gitlab.example.com/group/action=>myorg/sub/action")
+	require.Error(t, err, "the destination must be owner/repo")
+}
+
+func Test_parseRepoSpec_NestedNamespace(t *testing.T) {
+	spec, err := parseRepoSpec("group/sub/action@v1:myorg/action")
+	require.NoError(t, err)
+	assert.Equal(t, "group/sub/action", spec.origin)
+	assert.Equal(t, "myorg/action", spec.dest)
+	assert.Equal(t, "https://gitlab.example.com/group/sub/action", spec.gitURL("https://gitlab.example.com"))
+
+	// without a destination the cache mirrors the nested namespace
+	spec, err = parseRepoSpec("group/sub/action@v1")
+	require.NoError(t, err)
+	assert.Equal(t, "group/sub/action", spec.dest)
+	assert.Equal(t, "group/sub/action@v1", spec.entry())
+	assert.Equal(t, "group/sub-action", destinationNwo(spec.dest))
+	assert.Equal(t, "actions/checkout", destinationNwo("actions/checkout"))
+
+	_, err = parseRepoSpec("group/sub/action:myorg/sub/action")
+	require.Error(t, err, "the destination must be owner/repo")
+}
+
+func Test_getRepoNamesFromCSVString_SourceURLs(t *testing.T) {
+	repos, err := getRepoNamesFromCSVString("ssh://git@gitea.example.com/group/action.git=>myorg/action,actions/checkout,https://gitlab.example.com/group/cache.git@v4,v3=>myorg/cache")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ssh://git@gitea.example.com/group/action.git=>myorg/action",
+		"actions/checkout",
+		"https://gitlab.example.com/group/cache.git@v4,v3=>myorg/cache",
+	}, repos)
+}
+
+func Test_sourceAuth(t *testing.T) {
+	auth := gitAuthMethod("token")
+	assert.Equal(t, auth, sourceAuth("https://github.com/actions/checkout", "https://github.com", auth))
+	assert.Nil(t, sourceAuth("https://gitlab.example.com/group/action.git", "https://github.com", auth), "tokens stay with their host")
+	assert.Nil(t, sourceAuth("git@gitlab.example.com:group/action.git", "https://github.com", auth))
+}
+
+func TestPullWithGitImpl_SourceURLEntry(t *testing.T) {
+	root := t.TempDir()
+	initTestRepository(t, path.Join(root, "group", "sub", "action"))
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	entry := "file://" + path.Join(root, "group", "sub", "action") + "=>myorg/action"
+	err := PullWithGitImpl(context.Background(), flags, nil, entry, io.Discard, gitImplementation{})
+	require.NoError(t, err)
+
+	repoNames, err := getRepoNamesFromCacheDir(&flags.CommonFlags)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myorg/action"}, repoNames, "the cache is laid out by destination")
+}
+
+func TestPullAndPush_NestedNamespace(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	head := initTestRepository(t, path.Join(root, "group", "sub", "action"))
+
+	pullFlags := newTestPullFlags(t.TempDir(), false)
+	pullFlags.SourceURL = "file://" + root
+	require.NoError(t, PullWithGitImpl(ctx, pullFlags, nil, "group/sub/action", io.Discard, gitImplementation{}))
+	repoNames, err := getRepoNamesFromCacheDir(&pullFlags.CommonFlags)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group/sub/action"}, repoNames, "the cache mirrors the nested namespace")
+
+	destRoot := t.TempDir()
+	pushFlags, client := newTestReceiptPush(t, pullFlags.CacheDir, destRoot, "group/sub-action")
+	require.NoError(t, PushWithGitImpl(ctx, pushFlags, repoNames[0], io.Discard, client, gitImplementation{}))
+
+	dest, err := git.PlainOpen(path.Join(destRoot, "group", "sub-action"))
+	require.NoError(t, err)
+	ref, err := dest.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	assert.Equal(t, head, ref.Hash())
 }
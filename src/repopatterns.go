@@ -0,0 +1,270 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// repoSearchRegExp matches repo list entries that are a repository search,
+// such as `topic:github-action org:aws-actions`.
+var repoSearchRegExp = regexp.MustCompile(`^(topic|org|user|language|in|is|stars|pushed|created|archived|fork|license|repo):\S`)
+
+// repoPatternOptions are the filters applied when expanding wildcard and
+// search entries.
+type repoPatternOptions struct {
+	includeArchived, includeForks bool
+}
+
+// isRepoSearch reports whether a repo list entry is a repository search.
+func isRepoSearch(entry string) bool {
+	return strings.ContainsAny(strings.TrimSpace(entry), " \t") || repoSearchRegExp.MatchString(entry)
+}
+
+// isRepoWildcard reports whether a repo list entry names its repositories with
+// a glob, such as `actions/*` or `my-org/setup-*@v4`.
+func isRepoWildcard(entry string) bool {
+	if isRepoSearch(entry) {
+		return false
+	}
+	name, _, _ := strings.Cut(entry, "@")
+	return strings.ContainsAny(name, "*?[")
+}
+
+// hasRepoPatterns reports whether any entry needs expanding.
+func hasRepoPatterns(repoNames []string) bool {
+	for _, repoName := range repoNames {
+		if isRepoSearch(repoName) || isRepoWildcard(repoName) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternOwners returns repoNames with each search replaced by a wildcard for
+// the accounts in its org: and user: qualifiers, so the account that a GitHub
+// App is installed on can be found before the entries are expanded.
+func patternOwners(repoNames []string) []string {
+	owners := []string{}
+	for _, repoName := range repoNames {
+		if !isRepoSearch(repoName) {
+			owners = append(owners, repoName)
+			continue
+		}
+		for _, field := range strings.Fields(repoName) {
+			if owner, found := strings.CutPrefix(field, "org:"); found {
+				owners = append(owners, owner+"/*")
+			} else if owner, found := strings.CutPrefix(field, "user:"); found {
+				owners = append(owners, owner+"/*")
+			}
+		}
+	}
+	return owners
+}
+
+// newSourceClient returns a REST API client for the source, anonymous when ts
+// is nil.
+func newSourceClient(sourceURL string, ts oauth2.TokenSource, base http.RoundTripper) (*github.Client, error) {
+	if isSSHURL(sourceURL) {
+		return nil, errors.Errorf("wildcard and search entries are expanded with the API of --source-url, which `%s` does not have", sourceURL)
+	}
+	httpClient := &http.Client{Transport: base}
+	if ts != nil {
+		httpClient = newTokenHTTPClient(ts, base)
+	}
+	return newAPIClient(sourceURL, httpClient)
+}
+
+// splitRepoWildcard splits a wildcard entry into its owner, the glob for the
+// repository names and the pinned refs suffix that each match keeps.
+func splitRepoWildcard(entry string) (owner, pattern, refs string, err error) {
+	nwo, refs, found := strings.Cut(entry, "@")
+	if found {
+		refs = "@" + refs
+	}
+	if strings.Contains(entry, ":") {
+		return "", "", "", errors.Errorf("wildcard entry `%s` cannot have a destination", entry)
+	}
+	owner, pattern, err = splitNwo(nwo)
+	if err != nil {
+		return "", "", "", err
+	}
+	if strings.ContainsAny(owner, "*?[") {
+		return "", "", "", errors.Errorf("only the repository name of `%s` can contain wildcards", entry)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", "", "", errors.Errorf("`%s` is not a valid wildcard", entry)
+	}
+	return owner, strings.ToLower(pattern), refs, nil
+}
+
+// expandRepoPatterns replaces the wildcard and search entries in repoNames
+// with the repositories they match on the source, and prints what each one
+// resolved to so the list can be frozen. Entries naming a repository
+// explicitly take precedence over matches for the same repository.
+func expandRepoPatterns(ctx context.Context, client *github.Client, repoNames []string, opts repoPatternOptions, out io.Writer) ([]string, error) {
+	seen := map[string]bool{}
+	for _, repoName := range repoNames {
+		if isRepoSearch(repoName) || isRepoWildcard(repoName) {
+			continue
+		}
+		if spec, err := parseRepoSpec(repoName); err == nil {
+			seen[strings.ToLower(spec.origin)] = true
+		}
+	}
+
+	expanded := []string{}
+	for _, repoName := range repoNames {
+		var matches []string
+		var err error
+		switch {
+		case isRepoSearch(repoName):
+			matches, err = searchRepos(ctx, client, repoName, opts)
+		case isRepoWildcard(repoName):
+			matches, err = listWildcardRepos(ctx, client, repoName, opts)
+		default:
+			expanded = append(expanded, repoName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(out, "`%s` matches %d repositories:\n", repoName, len(matches))
+		for _, match := range matches {
+			fmt.Fprintln(out, match)
+			nwo, _, _ := strings.Cut(match, "@")
+			if seen[strings.ToLower(nwo)] {
+				continue
+			}
+			seen[strings.ToLower(nwo)] = true
+			expanded = append(expanded, match)
+		}
+	}
+	return expanded, nil
+}
+
+// listWildcardRepos lists the repositories of the wildcard entry's owner,
+// an organization or else a user, whose names match the wildcard.
+func listWildcardRepos(ctx context.Context, client *github.Client, entry string, opts repoPatternOptions) ([]string, error) {
+	owner, pattern, refs, err := splitRepoWildcard(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	add := func(repos []*github.Repository) {
+		for _, repo := range repos {
+			if (repo.GetArchived() && !opts.includeArchived) || (repo.GetFork() && !opts.includeForks) {
+				continue
+			}
+			if ok, _ := path.Match(pattern, strings.ToLower(repo.GetName())); ok {
+				matches = append(matches, repo.GetFullName()+refs)
+			}
+		}
+	}
+
+	orgOpts := &github.RepositoryListByOrgOptions{Type: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		repos, resp, err := client.Repositories.ListByOrg(ctx, owner, orgOpts)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			return nil, errors.Wrapf(err, "error listing the repositories of `%s`", owner)
+		}
+		add(repos)
+		if resp.NextPage == 0 {
+			return matches, nil
+		}
+		orgOpts.Page = resp.NextPage
+	}
+
+	// not an organization, so list the user's own repositories
+	userOpts := &github.RepositoryListOptions{Type: "owner", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		repos, resp, err := client.Repositories.List(ctx, owner, userOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing the repositories of `%s`", owner)
+		}
+		add(repos)
+		if resp.NextPage == 0 {
+			return matches, nil
+		}
+		userOpts.Page = resp.NextPage
+	}
+}
+
+// searchRepos returns the repositories found by the search query. Archived
+// repositories are left out unless included, forks unless the query or the
+// options ask for them. The search API stops at 1000 results, so queries
+// matching more fail rather than pull part of what they match.
+func searchRepos(ctx context.Context, client *github.Client, query string, opts repoPatternOptions) ([]string, error) {
+	query = strings.TrimSpace(query)
+	if !opts.includeArchived && !strings.Contains(query, "archived:") {
+		query += " archived:false"
+	}
+	if opts.includeForks && !strings.Contains(query, "fork:") {
+		query += " fork:true"
+	}
+
+	var matches []string
+	searchOpts := &github.SearchOptions{Sort: "stars", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		result, resp, err := client.Search.Repositories(ctx, query, searchOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error searching for repositories matching `%s`", query)
+		}
+		if result.GetIncompleteResults() {
+			return nil, errors.Errorf("the search for `%s` timed out before finding every repository, narrow it down", query)
+		}
+		for _, repo := range result.Repositories {
+			matches = append(matches, repo.GetFullName())
+		}
+		if resp.NextPage == 0 {
+			if len(matches) < result.GetTotal() {
+				return nil, errors.Errorf("the search for `%s` matches %d repositories but only the first %d can be listed, narrow it down", query, result.GetTotal(), len(matches))
+			}
+			return matches, nil
+		}
+		searchOpts.Page = resp.NextPage
+	}
+}
+
+// matchCachedRepos expands wildcard entries against the repositories in the
+// cache, for pushes of a list that wasn't expanded by pull. Searches need the
+// source API, so they can't be pushed this way.
+func matchCachedRepos(repoNames, cached []string) ([]string, error) {
+	expanded := []string{}
+	for _, repoName := range repoNames {
+		if isRepoSearch(repoName) {
+			return nil, errors.Errorf("search entry `%s` can only be expanded by pull, push with the list it printed instead", repoName)
+		}
+		if !isRepoWildcard(repoName) {
+			expanded = append(expanded, repoName)
+			continue
+		}
+		owner, pattern, refs, err := splitRepoWildcard(repoName)
+		if err != nil {
+			return nil, err
+		}
+		for _, nwo := range cached {
+			o, name, err := splitNwo(nwo)
+			if err != nil || !strings.EqualFold(o, owner) {
+				continue
+			}
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+				expanded = append(expanded, nwo+refs)
+			}
+		}
+	}
+	return expanded, nil
+}
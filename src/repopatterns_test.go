@@ -0,0 +1,127 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoPatternEntries(t *testing.T) {
+	for _, entry := range []string{"topic:github-action org:aws-actions", "org:actions", "language:go stars:>100"} {
+		assert.True(t, isRepoSearch(entry), entry)
+		assert.False(t, isRepoWildcard(entry), entry)
+	}
+	for _, entry := range []string{"actions/*", "my-org/setup-*", "actions/setup-*@v4", "actions/cache-[ab]"} {
+		assert.True(t, isRepoWildcard(entry), entry)
+		assert.False(t, isRepoSearch(entry), entry)
+	}
+	for _, entry := range []string{"actions/checkout", "actions/checkout@v4:myorg/checkout", "https://gitlab.example.com/group/action.git=>myorg/action"} {
+		assert.False(t, isRepoWildcard(entry), entry)
+		assert.False(t, isRepoSearch(entry), entry)
+	}
+
+	_, _, _, err := splitRepoWildcard("actions/*:myorg/all")
+	assert.Error(t, err, "wildcards cannot have a destination")
+	_, _, _, err = splitRepoWildcard("*/checkout")
+	assert.Error(t, err, "only repository names can be wildcards")
+	owner, pattern, refs, err := splitRepoWildcard("actions/Setup-*@v4,v3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions", "setup-*", "@v4,v3"}, []string{owner, pattern, refs})
+}
+
+func TestExpandRepoPatterns(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/orgs/actions/repos":
+			assert.Equal(t, "all", r.URL.Query().Get("type"))
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s/api/v3/orgs/actions/repos?type=all&page=2>; rel="next"`, r.Host))
+				fmt.Fprint(w, `[{"name": "setup-go", "full_name": "actions/setup-go"}, {"name": "checkout", "full_name": "actions/checkout"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"name": "setup-ruby", "full_name": "actions/setup-ruby", "archived": true}, {"name": "setup-node", "full_name": "actions/setup-node"}, {"name": "setup-fork", "full_name": "actions/setup-fork", "fork": true}]`)
+		case "/api/v3/orgs/monalisa/repos":
+			w.WriteHeader(http.StatusNotFound)
+		case "/api/v3/users/monalisa/repos":
+			fmt.Fprint(w, `[{"name": "my-action", "full_name": "monalisa/my-action"}]`)
+		case "/api/v3/search/repositories":
+			query = r.URL.Query().Get("q")
+			fmt.Fprint(w, `{"total_count": 2, "items": [{"full_name": "aws-actions/configure-aws-credentials"}, {"full_name": "actions/setup-go"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := newTestGitHubClient(t, server.URL)
+
+	var out bytes.Buffer
+	repoNames, err := expandRepoPatterns(context.Background(), client, []string{
+		"actions/setup-go@v5",
+		"actions/setup-*",
+		"monalisa/*",
+		"topic:github-action org:aws-actions",
+	}, repoPatternOptions{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/setup-go@v5", "actions/setup-node", "monalisa/my-action", "aws-actions/configure-aws-credentials"}, repoNames)
+	assert.Equal(t, "topic:github-action org:aws-actions archived:false", query)
+	assert.Contains(t, out.String(), "`actions/setup-*` matches 2 repositories:\nactions/setup-go\nactions/setup-node\n")
+
+	repoNames, err = expandRepoPatterns(context.Background(), client, []string{"actions/setup-*@v1"}, repoPatternOptions{includeArchived: true, includeForks: true}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/setup-go@v1", "actions/setup-ruby@v1", "actions/setup-node@v1", "actions/setup-fork@v1"}, repoNames)
+
+	_, err = expandRepoPatterns(context.Background(), client, []string{"language:go fork:true"}, repoPatternOptions{includeForks: true}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, "language:go fork:true archived:false", query)
+}
+
+func TestSearchRepos_Truncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the search API stops paginating at 1000 results whatever the total
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/api/v3/search/repositories?page=2>; rel="next"`, r.Host))
+			fmt.Fprint(w, `{"total_count": 1500, "items": [{"full_name": "actions/checkout"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"total_count": 1500, "items": [{"full_name": "actions/cache"}]}`)
+	}))
+	defer server.Close()
+	client := newTestGitHubClient(t, server.URL)
+
+	_, err := searchRepos(context.Background(), client, "topic:github-action", repoPatternOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "matches 1500 repositories but only the first 2 can be listed")
+}
+
+func TestMatchCachedRepos(t *testing.T) {
+	cached := []string{"actions/checkout", "actions/setup-go", "actions/setup-node", "monalisa/setup-go"}
+
+	repoNames, err := matchCachedRepos([]string{"actions/setup-*@v4", "monalisa/setup-go"}, cached)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions/setup-go@v4", "actions/setup-node@v4", "monalisa/setup-go"}, repoNames)
+
+	_, err = matchCachedRepos([]string{"topic:github-action"}, cached)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can only be expanded by pull")
+}
+
+func TestPatternOwners(t *testing.T) {
+	assert.Equal(t, []string{"actions/*", "aws-actions/*"}, patternOwners([]string{"actions/*", "topic:github-action org:aws-actions"}))
+
+	owners, err := repoOwners(patternOwners([]string{"topic:github-action org:myorg", "myorg/setup-*"}), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myorg"}, owners)
+}
+
+func TestNewSourceClient_SSH(t *testing.T) {
+	_, err := newSourceClient("git@github.com:", nil, http.DefaultTransport)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--source-url")
+}
@@ -0,0 +1,168 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/semver"
+)
+
+var (
+	// releaseTagRegExp matches full release versions such as `v1.2.3` or
+	// `1.2.3-beta.1`.
+	releaseTagRegExp = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	// floatingTagRegExp matches floating major or minor tags such as `v4` or
+	// `v4.1` that are moved along with each release.
+	floatingTagRegExp = regexp.MustCompile(`^v?\d+(\.\d+)?$`)
+	// semverConstraintRegExp matches a single comparator such as `>=3.0.0`.
+	semverConstraintRegExp = regexp.MustCompile(`^(>=|<=|!=|>|<|=)?(v?\d+(\.\d+)?(\.\d+)?(-[0-9A-Za-z.-]+)?)$`)
+	// semverOperatorSpaceRegExp matches the whitespace after an operator, as
+	// in `>= 3.0.0`, which is dropped before the constraint is split.
+	semverOperatorSpaceRegExp = regexp.MustCompile(`(>=|<=|!=|>|<|=)\s+`)
+)
+
+type semverConstraint struct {
+	op, version string
+}
+
+func (c semverConstraint) allows(version string) bool {
+	cmp := semver.Compare(version, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		// `<4` excludes prereleases of 4.0.0 even though they sort before it,
+		// matching what people mean by an upper bound.
+		if semver.Prerelease(version) != "" && semver.Prerelease(c.version) == "" &&
+			semver.Compare(strings.TrimSuffix(version, semver.Prerelease(version)+semver.Build(version)), c.version) == 0 {
+			return false
+		}
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// semverSelection narrows release tags down using semantic versioning. Only
+// tags that look like versions are affected; any other tags are left alone.
+// Floating tags such as `v4` or `v4.1` are kept when they point at the same
+// commit as a selected release and dropped otherwise.
+type semverSelection struct {
+	constraints      []semverConstraint
+	keepLatestMajors int
+	skipPrerelease   bool
+}
+
+// newSemverSelection parses a constraint made of comparators separated by
+// whitespace or commas, all of which must hold, e.g. `>=3.0.0 <5` or
+// `>= 3.0.0, < 5`.
+func newSemverSelection(constraint string, keepLatestMajors int, skipPrerelease bool) (*semverSelection, error) {
+	if keepLatestMajors < 0 {
+		return nil, fmt.Errorf("--keep-latest-majors cannot be negative")
+	}
+
+	s := &semverSelection{keepLatestMajors: keepLatestMajors, skipPrerelease: skipPrerelease}
+	constraint = semverOperatorSpaceRegExp.ReplaceAllString(constraint, "$1")
+	for _, part := range strings.FieldsFunc(constraint, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		m := semverConstraintRegExp.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("`%s` is not a valid semver constraint, use comparators such as `>=3.0.0` or `<5`", part)
+		}
+		s.constraints = append(s.constraints, semverConstraint{op: m[1], version: canonicalVersion(m[2])})
+	}
+	return s, nil
+}
+
+// IsEmpty reports whether the selection keeps every tag.
+func (s *semverSelection) IsEmpty() bool {
+	return s == nil || (len(s.constraints) == 0 && s.keepLatestMajors == 0 && !s.skipPrerelease)
+}
+
+// Select returns the tags to drop given every tag and the commit it points at.
+func (s *semverSelection) Select(tags map[plumbing.ReferenceName]plumbing.Hash) map[plumbing.ReferenceName]bool {
+	dropped := map[plumbing.ReferenceName]bool{}
+	if s.IsEmpty() {
+		return dropped
+	}
+
+	releases := map[plumbing.ReferenceName]string{}
+	var floating []plumbing.ReferenceName
+	for name := range tags {
+		short := name.Short()
+		switch {
+		case releaseTagRegExp.MatchString(short):
+			releases[name] = canonicalVersion(short)
+		case floatingTagRegExp.MatchString(short):
+			floating = append(floating, name)
+		}
+	}
+
+	majors := map[string]bool{}
+	for name, version := range releases {
+		if !s.allows(version) {
+			dropped[name] = true
+			continue
+		}
+		majors[semver.Major(version)] = true
+	}
+
+	if s.keepLatestMajors > 0 && len(majors) > s.keepLatestMajors {
+		sorted := make([]string, 0, len(majors))
+		for major := range majors {
+			sorted = append(sorted, major)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return semver.Compare(sorted[i], sorted[j]) > 0 })
+		for _, major := range sorted[s.keepLatestMajors:] {
+			delete(majors, major)
+		}
+		for name, version := range releases {
+			if !majors[semver.Major(version)] {
+				dropped[name] = true
+			}
+		}
+	}
+
+	selectedCommits := map[plumbing.Hash]bool{}
+	for name := range releases {
+		if !dropped[name] {
+			selectedCommits[tags[name]] = true
+		}
+	}
+	for _, name := range floating {
+		if !selectedCommits[tags[name]] {
+			dropped[name] = true
+		}
+	}
+
+	return dropped
+}
+
+func (s *semverSelection) allows(version string) bool {
+	if s.skipPrerelease && semver.Prerelease(version) != "" {
+		return false
+	}
+	for _, c := range s.constraints {
+		if !c.allows(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalVersion adds the `v` prefix expected by the semver package.
+func canonicalVersion(version string) string {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version
+}
@@ -0,0 +1,133 @@
+package src
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTags builds a tag name -> commit map from "tag=commit" pairs where the
+// commit is a short fake identifier.
+func testTags(pairs map[string]string) map[plumbing.ReferenceName]plumbing.Hash {
+	tags := map[plumbing.ReferenceName]plumbing.Hash{}
+	for tag, commit := range pairs {
+		tags[plumbing.NewTagReferenceName(tag)] = plumbing.NewHash(commit)
+	}
+	return tags
+}
+
+func keptTags(tags map[plumbing.ReferenceName]plumbing.Hash, dropped map[plumbing.ReferenceName]bool) []string {
+	var kept []string
+	for name := range tags {
+		if !dropped[name] {
+			kept = append(kept, name.Short())
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+var semverTestTags = map[string]string{
+	"v2.1.0":        "a1",
+	"v2":            "a1",
+	"v3.0.0":        "b1",
+	"v3.6.0":        "b2",
+	"v3":            "b2",
+	"v4.0.0-beta.1": "c0",
+	"v4.0.0":        "c1",
+	"v4.1.0":        "c2",
+	"v4.1":          "c2",
+	"v4":            "c2",
+	"latest":        "c2",
+	"v1":            "zz",
+}
+
+func TestSemverSelection(t *testing.T) {
+	tests := []struct {
+		name             string
+		constraint       string
+		keepLatestMajors int
+		skipPrerelease   bool
+		expected         []string
+	}{
+		{
+			name:     "empty selection keeps everything",
+			expected: []string{"latest", "v1", "v2", "v2.1.0", "v3", "v3.0.0", "v3.6.0", "v4", "v4.0.0", "v4.0.0-beta.1", "v4.1", "v4.1.0"},
+		},
+		{
+			name:       "constraint",
+			constraint: ">=3.0.0",
+			expected:   []string{"latest", "v3", "v3.0.0", "v3.6.0", "v4", "v4.0.0", "v4.0.0-beta.1", "v4.1", "v4.1.0"},
+		},
+		{
+			name:       "range constraint",
+			constraint: ">=3.0.0, <4",
+			expected:   []string{"latest", "v3", "v3.0.0", "v3.6.0"},
+		},
+		{
+			name:       "range constraint with spaces after the operators",
+			constraint: " >= 3.0.0 ,\t<  4 ",
+			expected:   []string{"latest", "v3", "v3.0.0", "v3.6.0"},
+		},
+		{
+			name:             "latest majors",
+			keepLatestMajors: 2,
+			expected:         []string{"latest", "v3", "v3.0.0", "v3.6.0", "v4", "v4.0.0", "v4.0.0-beta.1", "v4.1", "v4.1.0"},
+		},
+		{
+			name:             "latest major without prereleases",
+			keepLatestMajors: 1,
+			skipPrerelease:   true,
+			expected:         []string{"latest", "v4", "v4.0.0", "v4.1", "v4.1.0"},
+		},
+		{
+			name:       "floating tag pointing at a dropped release is dropped",
+			constraint: "=3.0.0",
+			expected:   []string{"latest", "v3.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selection, err := newSemverSelection(tt.constraint, tt.keepLatestMajors, tt.skipPrerelease)
+			require.NoError(t, err)
+
+			tags := testTags(semverTestTags)
+			assert.Equal(t, tt.expected, keptTags(tags, selection.Select(tags)))
+		})
+	}
+}
+
+func TestSemverSelection_VersionsWithoutPrefix(t *testing.T) {
+	selection, err := newSemverSelection(">=2", 0, false)
+	require.NoError(t, err)
+
+	tags := testTags(map[string]string{"1.9.0": "a1", "2.0.0": "b1"})
+	assert.Equal(t, []string{"2.0.0"}, keptTags(tags, selection.Select(tags)))
+}
+
+func TestNewSemverSelection_Invalid(t *testing.T) {
+	_, err := newSemverSelection(">=three", 0, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a valid semver constraint")
+
+	_, err = newSemverSelection("", -1, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--keep-latest-majors")
+}
+
+func TestSemverSelection_IsEmpty(t *testing.T) {
+	var nilSelection *semverSelection
+	assert.True(t, nilSelection.IsEmpty())
+
+	selection, err := newSemverSelection("", 0, false)
+	require.NoError(t, err)
+	assert.True(t, selection.IsEmpty())
+
+	selection, err = newSemverSelection("", 0, true)
+	require.NoError(t, err)
+	assert.False(t, selection.IsEmpty())
+}
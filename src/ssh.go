@@ -0,0 +1,97 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/pkg/errors"
+)
+
+// scpURLRegExp matches scp-style git URLs such as `git@github.com:` or
+// `git@github.com:org`.
+var scpURLRegExp = regexp.MustCompile(`^(?:[^@/:]+@)?[^@/:]+:([^/].*)?$`)
+
+// SSHFlags configure git over SSH for the source or the destination.
+type SSHFlags struct {
+	KeyFile, KnownHostsFile string
+	Agent                   bool
+}
+
+// Validate checks the SSH flags, prefix is the flag prefix such as `source`.
+func (f *SSHFlags) Validate(prefix string) Validations {
+	var validations Validations
+	if f.KeyFile != "" && f.Agent {
+		validations = append(validations, fmt.Sprintf("only one of --%[1]s-ssh-key-file and --%[1]s-ssh-agent can be set", prefix))
+	}
+	return validations
+}
+
+// IsSet reports whether any SSH option was given.
+func (f *SSHFlags) IsSet() bool {
+	return f.KeyFile != "" || f.KnownHostsFile != "" || f.Agent
+}
+
+// AuthMethod returns the git auth for connecting to gitURL over SSH: the key
+// in the key file, or else the keys held by ssh-agent. Host keys are checked
+// against the known hosts file, falling back to SSH_KNOWN_HOSTS and
+// ~/.ssh/known_hosts.
+func (f *SSHFlags) AuthMethod(gitURL string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(gitURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing git URL `%s`", gitURL)
+	}
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+
+	var hostKeyCallback *gitssh.HostKeyCallbackHelper
+	if f.KnownHostsFile != "" {
+		callback, err := gitssh.NewKnownHostsCallback(f.KnownHostsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading the SSH known hosts file")
+		}
+		hostKeyCallback = &gitssh.HostKeyCallbackHelper{HostKeyCallback: callback}
+	}
+
+	if f.KeyFile != "" {
+		auth, err := gitssh.NewPublicKeysFromFile(user, f.KeyFile, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading the SSH key file, use ssh-agent for keys with a passphrase")
+		}
+		if hostKeyCallback != nil {
+			auth.HostKeyCallbackHelper = *hostKeyCallback
+		}
+		return auth, nil
+	}
+	auth, err := gitssh.NewSSHAgentAuth(user)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to ssh-agent, is SSH_AUTH_SOCK set?")
+	}
+	if hostKeyCallback != nil {
+		auth.HostKeyCallbackHelper = *hostKeyCallback
+	}
+	return auth, nil
+}
+
+// isSSHURL reports whether gitURL is an `ssh://` or scp-style URL.
+func isSSHURL(gitURL string) bool {
+	lower := strings.ToLower(gitURL)
+	if strings.HasPrefix(lower, "ssh://") || strings.HasPrefix(lower, "git+ssh://") {
+		return true
+	}
+	return !strings.Contains(gitURL, "://") && scpURLRegExp.MatchString(gitURL)
+}
+
+// repoGitURL returns the URL of the repository nwo under base, which is an
+// HTTP(S) or SSH URL. A bare scp-style host such as `git@github.com:` is
+// followed by the path directly.
+func repoGitURL(base, nwo string) string {
+	if !strings.Contains(base, "://") && strings.HasSuffix(base, ":") {
+		return base + nwo
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), nwo)
+}
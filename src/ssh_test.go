@@ -0,0 +1,219 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/google/go-github/v43/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestIsSSHURL(t *testing.T) {
+	for _, u := range []string{"ssh://git@github.com", "SSH://github.com:2222", "git@github.com:", "git@ghes.example.com:myorg", "github.com:"} {
+		assert.True(t, isSSHURL(u), u)
+	}
+	for _, u := range []string{"https://github.com", "file:///tmp/repos", "", "/tmp/repos"} {
+		assert.False(t, isSSHURL(u), u)
+	}
+}
+
+func TestRepoGitURL(t *testing.T) {
+	assert.Equal(t, "https://github.com/actions/checkout", repoGitURL("https://github.com", "actions/checkout"))
+	assert.Equal(t, "https://github.com/actions/checkout", repoGitURL("https://github.com/", "actions/checkout"))
+	assert.Equal(t, "ssh://git@github.com/actions/checkout", repoGitURL("ssh://git@github.com", "actions/checkout"))
+	assert.Equal(t, "git@github.com:actions/checkout", repoGitURL("git@github.com:", "actions/checkout"))
+}
+
+func TestSSHFlags_Validate(t *testing.T) {
+	pull := &PullOnlyFlags{SourceURL: "https://github.com", SourceSSH: SSHFlags{KeyFile: "id_ed25519"}}
+	validations := pull.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--source-url")
+
+	push := &PushOnlyFlags{BaseURL: "https://ghes.example.com", Token: "token", GitURL: "git@ghes.example.com:", DestinationSSH: SSHFlags{KeyFile: "id_ed25519", Agent: true}}
+	validations = push.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--destination-ssh-agent")
+
+	push = &PushOnlyFlags{BaseURL: "https://ghes.example.com", Token: "token", GitURL: "ftp://ghes.example.com"}
+	require.Len(t, push.Validate(), 1)
+}
+
+// newTestSSHServer serves the git repositories under root over SSH to clients
+// holding clientKey. It returns the server's address and a known_hosts file
+// with its host key.
+func newTestSSHServer(t *testing.T, root string, clientKey ssh.PublicKey) (addr, knownHostsFile string) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, config, root)
+		}
+	}()
+
+	addr = listener.Addr().String()
+	knownHostsFile = path.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600))
+	return addr, knownHostsFile
+}
+
+// serveTestSSHConn runs the git-upload-pack and git-receive-pack commands
+// requested on conn against the repositories under root.
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig, root string) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					_ = req.Reply(false, nil)
+					return
+				}
+				_ = req.Reply(true, nil)
+
+				fields := strings.SplitN(payload.Command, " ", 2)
+				repoDir := path.Join(root, strings.Trim(fields[1], "'"))
+				cmd := exec.Command("git", strings.TrimPrefix(fields[0], "git-"), repoDir)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				stdin, _ := cmd.StdinPipe()
+				go func() {
+					_, _ = io.Copy(stdin, channel)
+					stdin.Close()
+				}()
+				status := struct{ Status uint32 }{}
+				if err := cmd.Run(); err != nil {
+					status.Status = 1
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+				return
+			}
+		}()
+	}
+}
+
+// writeTestSSHKey writes a new private key to disk and returns its path and
+// public key.
+func writeTestSSHKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	keyFile := path.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return keyFile, sshPub
+}
+
+func TestPullAndPushOverSSH(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	keyFile, pub := writeTestSSHKey(t)
+	root := t.TempDir()
+	initTestRepository(t, path.Join(root, "actions", "checkout"))
+	dest, err := git.PlainInit(path.Join(root, "myorg", "checkout"), true)
+	require.NoError(t, err)
+	addr, knownHostsFile := newTestSSHServer(t, root, pub)
+	sshFlags := SSHFlags{KeyFile: keyFile, KnownHostsFile: knownHostsFile}
+
+	// pull from an ssh:// URL
+	pullFlags := newTestPullFlags(t.TempDir(), false)
+	pullFlags.SourceURL = "ssh://git@" + addr
+	pullFlags.SourceSSH = sshFlags
+	require.Empty(t, pullFlags.PullOnlyFlags.Validate())
+	auth, err := pullFlags.SourceSSH.AuthMethod(pullFlags.SourceURL)
+	require.NoError(t, err)
+	err = PullWithGitImpl(context.Background(), pullFlags, auth, "actions/checkout:myorg/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	cacheDir := path.Join(pullFlags.CacheDir, "myorg", "checkout")
+	cache, err := git.PlainOpen(cacheDir)
+	require.NoError(t, err)
+	_, err = cache.Reference("refs/tags/v1.0.0", false)
+	require.NoError(t, err)
+
+	// push to an scp-style URL, the clone URL from the API is not used
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	pushFlags := &PushFlags{PushOnlyFlags: PushOnlyFlags{GitURL: "git@" + host + ":" + port + ":", DestinationSSH: sshFlags}}
+	pushFlags.destination.auth, err = sshFlags.AuthMethod(pushFlags.GitURL)
+	require.NoError(t, err)
+	cloneURL := "https://ghes.example.com/myorg/checkout.git"
+	fullName := "myorg/checkout"
+	_, err = syncWithCachedRepository(context.Background(), pushFlags, &github.Repository{CloneURL: &cloneURL, FullName: &fullName}, cacheDir, nil, io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	_, err = dest.Reference("refs/heads/main", false)
+	assert.NoError(t, err)
+	_, err = dest.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err)
+}
+
+func TestSSHFlags_AuthMethod_UnknownHost(t *testing.T) {
+	keyFile, pub := writeTestSSHKey(t)
+	root := t.TempDir()
+	initTestRepository(t, path.Join(root, "actions", "checkout"))
+	addr, _ := newTestSSHServer(t, root, pub)
+
+	emptyKnownHosts := path.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(emptyKnownHosts, nil, 0o600))
+	auth, err := (&SSHFlags{KeyFile: keyFile, KnownHostsFile: emptyKnownHosts}).AuthMethod("ssh://git@" + addr)
+	require.NoError(t, err)
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.SourceURL = "ssh://git@" + addr
+	err = PullWithGitImpl(context.Background(), flags, auth, "actions/checkout", io.Discard, gitImplementation{})
+	require.Error(t, err, "hosts missing from known_hosts are rejected")
+}
@@ -3,6 +3,7 @@ package src
 import (
 	"context"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -19,7 +20,11 @@ func (f *SyncFlags) Init(cmd *cobra.Command) {
 }
 
 func (f *SyncFlags) Validate() Validations {
-	return f.CommonFlags.Validate(true).Join(f.PullOnlyFlags.Validate().Join(f.PushOnlyFlags.Validate()))
+	validations := f.CommonFlags.Validate(len(f.SourceOrgs) == 0).Join(f.PullOnlyFlags.Validate().Join(f.PushOnlyFlags.Validate()))
+	if f.FromBundle != "" {
+		validations = append(validations, "--from-bundle can only be used with push")
+	}
+	return validations
 }
 
 func Sync(ctx context.Context, flags *SyncFlags) error {
@@ -27,10 +32,25 @@ func Sync(ctx context.Context, flags *SyncFlags) error {
 	pullFlags := &PullFlags{flags.CommonFlags, flags.PullOnlyFlags}
 	pushFlags := &PushFlags{flags.CommonFlags, flags.PushOnlyFlags}
 
-	if err := Pull(ctx, pullFlags); err != nil {
-		return err
+	pullErr := Pull(ctx, pullFlags)
+	if pullErr != nil && !flags.ContinueOnError {
+		return pullErr
+	}
+	pushFlags.repoNames = pullFlags.repoNames
+	pushFlags.manifest = pullFlags.manifest
+	// The manifest was just written by this process, so it can be verified
+	if pushFlags.IntegrityDigest == "" {
+		pushFlags.IntegrityDigest = pullFlags.manifestDigest
 	}
 
+	// With --continue-on-error a partial pull still pushes whatever is in the
+	// cache, so one broken upstream repository doesn't hold back the others.
 	err := Push(ctx, pushFlags)
+	if pullErr != nil {
+		if err != nil {
+			return errors.Wrapf(err, "pull failed (%s)", pullErr)
+		}
+		return errors.Wrap(pullErr, "pull failed")
+	}
 	return err
 }
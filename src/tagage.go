@@ -0,0 +1,132 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var ageDaysRegExp = regexp.MustCompile(`^(\d+)d(.*)$`)
+
+// parseAge parses an age such as `7d`, `36h` or `1d12h`. Apart from the `d`
+// suffix for days it accepts anything time.ParseDuration does.
+func parseAge(s string) (time.Duration, error) {
+	var age time.Duration
+	rest := s
+	if m := ageDaysRegExp.FindStringSubmatch(s); m != nil {
+		days, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, fmt.Errorf("`%s` is not a valid age, use a duration such as `7d` or `36h`", s)
+		}
+		age = time.Duration(days) * 24 * time.Hour
+		rest = m[2]
+	}
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("`%s` is not a valid age, use a duration such as `7d` or `36h`", s)
+		}
+		age += d
+	}
+	if age < 0 {
+		return 0, fmt.Errorf("`%s` is not a valid age, it cannot be negative", s)
+	}
+	return age, nil
+}
+
+// formatAge prints an age in whole days once it is at least a day old.
+func formatAge(age time.Duration) string {
+	if age >= 24*time.Hour {
+		return fmt.Sprintf("%dd", age/(24*time.Hour))
+	}
+	return age.Round(time.Minute).String()
+}
+
+// tagTime returns when a tag was published: the tagger date of an annotated tag
+// or the committer date of the commit a lightweight tag points at.
+func tagTime(repo GitRepository, hash plumbing.Hash) (time.Time, error) {
+	if tag, err := repo.TagObject(hash); err == nil {
+		return tag.Tagger.When, nil
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return commit.Committer.When, nil
+}
+
+// tooYoung checks whether the tag at hash was published less than minAge
+// before now. It returns why the tag should be held back, or an empty string
+// when it is old enough. Tags whose age can't be determined are held back.
+func tooYoung(repo GitRepository, hash plumbing.Hash, minAge time.Duration, now time.Time) string {
+	published, err := tagTime(repo, hash)
+	if err != nil {
+		return fmt.Sprintf("its age could not be determined (%s)", err)
+	}
+	if age := now.Sub(published); age < minAge {
+		return fmt.Sprintf("it is %s old which is less than --min-tag-age %s", formatAge(age), formatAge(minAge))
+	}
+	return ""
+}
+
+// holdYoungTags runs after a fetch. Tags that are new or changed since before
+// and were published less than minAge ago are put back the way they were, so
+// they are only cached once they have been public for long enough.
+func holdYoungTags(repo GitRepository, before map[plumbing.ReferenceName]plumbing.Hash, minAge time.Duration, now time.Time, repoName string, out io.Writer) error {
+	refs, err := hashReferences(repo)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		name := ref.Name()
+		if !name.IsTag() {
+			continue
+		}
+		old, existed := before[name]
+		if existed && old == ref.Hash() {
+			continue
+		}
+		reason := tooYoung(repo, ref.Hash(), minAge, now)
+		if reason == "" {
+			continue
+		}
+		if existed {
+			err = repo.SetReference(plumbing.NewHashReference(name, old))
+		} else {
+			err = repo.RemoveReference(name)
+		}
+		if err != nil {
+			return fmt.Errorf("could not hold back %s: %w", name, err)
+		}
+		fmt.Fprintf(out, "skipping tag %s of %s, %s\n", name.Short(), repoName, reason)
+	}
+	return nil
+}
+
+// dropYoungTags removes the tags published less than minAge ago from refs.
+func dropYoungTags(repo GitRepository, refs []plumbing.ReferenceName, minAge time.Duration, now time.Time, repoName string, out io.Writer) ([]plumbing.ReferenceName, error) {
+	all, err := hashReferences(repo)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range all {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	var kept []plumbing.ReferenceName
+	for _, name := range refs {
+		if name.IsTag() {
+			if reason := tooYoung(repo, hashes[name], minAge, now); reason != "" {
+				fmt.Fprintf(out, "skipping tag %s of %s, %s\n", name.Short(), repoName, reason)
+				continue
+			}
+		}
+		kept = append(kept, name)
+	}
+	return kept, nil
+}
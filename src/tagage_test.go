@@ -0,0 +1,137 @@
+package src
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v43/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"0d", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseAge(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, in := range []string{"7", "d", "7 days", "-1h", "1w"} {
+		_, err := parseAge(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestCommonFlags_Validate_MinTagAge(t *testing.T) {
+	f := &CommonFlags{MinTagAge: "a week"}
+	validations := f.Validate(false)
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--min-tag-age")
+}
+
+// initAgedTestRepository creates an upstream repository with a month old
+// commit tagged v1.0.0, an annotated v1.0.1 tag on the same commit created just
+// now and a new commit on main tagged v1.1.0.
+func initAgedTestRepository(t *testing.T, dir string) {
+	t.Helper()
+
+	repo, err := git.PlainInitWithOptions(dir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	old := commitTestFile(t, dir, "name: old\n", time.Now().Add(-30*24*time.Hour))
+	_, err = repo.CreateTag("v1.0.0", old, nil)
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v1.0.1", old, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		Message: "v1.0.1",
+	})
+	require.NoError(t, err)
+	recent := commitTestFile(t, dir, "name: new\n", time.Now())
+	_, err = repo.CreateTag("v1.1.0", recent, nil)
+	require.NoError(t, err)
+}
+
+func TestPullWithGitImpl_MinTagAge(t *testing.T) {
+	srcDir := path.Join(t.TempDir(), "actions", "checkout")
+	initAgedTestRepository(t, srcDir)
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.SourceURL = "file://" + path.Dir(path.Dir(srcDir))
+	flags.MinTagAge = "7d"
+	var out strings.Builder
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "skipping tag v1.1.0 of actions/checkout, it is ")
+	assert.Contains(t, out.String(), "old which is less than --min-tag-age 7d")
+	assert.Contains(t, out.String(), "skipping tag v1.0.1 of actions/checkout")
+
+	cache, err := git.PlainOpen(path.Join(flags.CacheDir, "actions", "checkout"))
+	require.NoError(t, err)
+	_, err = cache.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err, "tags older than the window should be cached")
+	_, err = cache.Reference("refs/tags/v1.0.1", false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound, "annotated tags are dated by the tagger")
+	_, err = cache.Reference("refs/tags/v1.1.0", false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = cache.Reference("refs/heads/main", false)
+	assert.NoError(t, err, "branches are not held back")
+}
+
+func TestSyncWithCachedRepository_MinTagAge(t *testing.T) {
+	repoDir := path.Join(t.TempDir(), "actions", "checkout")
+	initAgedTestRepository(t, repoDir)
+	destDir := t.TempDir()
+	dest, err := git.PlainInit(destDir, true)
+	require.NoError(t, err)
+	cloneURL := "file://" + destDir
+	fullName := "actions/checkout"
+
+	flags := &PushFlags{
+		CommonFlags:   CommonFlags{MinTagAge: "7d"},
+		PushOnlyFlags: PushOnlyFlags{DisableGitAuth: true},
+	}
+	var out strings.Builder
+	_, err = syncWithCachedRepository(context.Background(), flags, &github.Repository{CloneURL: &cloneURL, FullName: &fullName}, repoDir, nil, &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "skipping tag v1.1.0 of actions/checkout")
+
+	_, err = dest.Reference("refs/tags/v1.0.0", false)
+	assert.NoError(t, err)
+	_, err = dest.Reference("refs/tags/v1.1.0", false)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = dest.Reference("refs/heads/main", false)
+	assert.NoError(t, err)
+}
+
+func TestDropYoungTags_UnknownAgeIsHeldBack(t *testing.T) {
+	repo := &mockGitRepository{refs: testRemoteRefs("refs/heads/main", "refs/tags/v1.0.0")}
+
+	var out strings.Builder
+	refs, err := dropYoungTags(repo, []plumbing.ReferenceName{"refs/heads/main", "refs/tags/v1.0.0"}, time.Hour, time.Now(), "actions/checkout", &out)
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.ReferenceName{"refs/heads/main"}, refs)
+	assert.Contains(t, out.String(), "its age could not be determined")
+
+	_, err = dropYoungTags(repo, nil, time.Hour, time.Now(), "actions/checkout", io.Discard)
+	require.NoError(t, err)
+}
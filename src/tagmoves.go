@@ -0,0 +1,116 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// previousTagsPrefix is where pull keeps the old target of tags that moved
+// upstream, so the commit they used to point at stays in the cache. Refs there
+// are neither branches nor tags, so they are never pushed.
+const previousTagsPrefix = "refs/actions-sync/previous-tags/"
+
+// movedTag is a cached tag that points at a different commit upstream.
+type movedTag struct {
+	name           plumbing.ReferenceName
+	old            plumbing.Hash
+	oldCommit, new plumbing.Hash
+}
+
+// findMovedTags compares the cached tags with the ones on the origin remote and
+// returns those that now point at a different commit. Tags whose short name
+// matches floating, such as `v4`, are expected to move and are left out. A tag
+// object that was recreated for the same commit doesn't count as a move.
+func findMovedTags(ctx context.Context, repo GitRepository, origin *originRefs, floating *regexp.Regexp) ([]movedTag, error) {
+	remoteRefs, targets, err := origin.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := hashReferences(repo)
+	if err != nil {
+		return nil, err
+	}
+	local := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range cached {
+		if ref.Name().IsTag() {
+			local[ref.Name()] = ref.Hash()
+		}
+	}
+
+	var moved []movedTag
+	for _, ref := range remoteRefs {
+		name := ref.Name()
+		if !name.IsTag() || floating.MatchString(name.Short()) {
+			continue
+		}
+		old, ok := local[name]
+		if !ok || old == ref.Hash() {
+			continue
+		}
+		newCommit := ref.Hash()
+		if target, ok := targets[name]; ok {
+			newCommit = target
+		}
+		oldCommit := peelTag(repo, old)
+		if oldCommit == newCommit {
+			continue
+		}
+		moved = append(moved, movedTag{name: name, old: old, oldCommit: oldCommit, new: newCommit})
+	}
+	return moved, nil
+}
+
+func previousTagRefName(name plumbing.ReferenceName) plumbing.ReferenceName {
+	return plumbing.ReferenceName(previousTagsPrefix + name.Short())
+}
+
+// fetchedTags keeps the moved tags that the fetch is going to update.
+func fetchedTags(moved []movedTag, refSpecs []config.RefSpec, tags git.TagMode) []movedTag {
+	if tags == git.AllTags {
+		return moved
+	}
+	fetched := map[plumbing.ReferenceName]bool{}
+	for _, refSpec := range refSpecs {
+		fetched[plumbing.ReferenceName(refSpec.Src())] = true
+	}
+	var kept []movedTag
+	for _, tag := range moved {
+		if fetched[tag.name] {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// holdMovedTags runs after the fetch. It records where every moved tag used to
+// point and, unless allowMoves is set, puts the tag back and fails so the move
+// gets looked at before it is synced anywhere.
+func holdMovedTags(repo GitRepository, moved []movedTag, allowMoves bool, repoName string, out io.Writer) error {
+	var held []string
+	for _, tag := range moved {
+		if err := repo.SetReference(plumbing.NewHashReference(previousTagRefName(tag.name), tag.old)); err != nil {
+			return fmt.Errorf("could not record the previous target of %s: %w", tag.name, err)
+		}
+		if allowMoves {
+			fmt.Fprintf(out, "WARNING: tag %s of %s moved upstream from %s to %s, updating it because --allow-tag-moves is set\n", tag.name.Short(), repoName, tag.oldCommit, tag.new)
+			continue
+		}
+		if err := repo.SetReference(plumbing.NewHashReference(tag.name, tag.old)); err != nil {
+			return fmt.Errorf("could not restore %s: %w", tag.name, err)
+		}
+		fmt.Fprintf(out, "WARNING: tag %s of %s moved upstream from %s to %s, keeping the cached tag\n", tag.name.Short(), repoName, tag.oldCommit, tag.new)
+		held = append(held, tag.name.Short())
+	}
+	if len(held) > 0 {
+		return fmt.Errorf("refusing to update moved tags of %s (%s), pass --allow-tag-moves to accept them", repoName, strings.Join(held, ", "))
+	}
+	return nil
+}
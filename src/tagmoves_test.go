@@ -0,0 +1,91 @@
+package src
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupMovedTag creates an upstream repository with v1.0.0 and v1 tags, pulls
+// it into a new cache and then moves both tags upstream to a new commit.
+func setupMovedTag(t *testing.T) (*PullFlags, plumbing.Hash, plumbing.Hash) {
+	t.Helper()
+
+	srcDir := path.Join(t.TempDir(), "actions", "checkout")
+	oldHash := initTestRepository(t, srcDir)
+	src, err := git.PlainOpen(srcDir)
+	require.NoError(t, err)
+	require.NoError(t, src.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1", oldHash)))
+
+	flags := newTestPullFlags(t.TempDir(), false)
+	flags.SourceURL = "file://" + path.Dir(path.Dir(srcDir))
+	err = PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+
+	newHash := commitTestFile(t, srcDir, "name: moved\n", time.Now())
+	require.NoError(t, src.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", newHash)))
+	require.NoError(t, src.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1", newHash)))
+
+	return flags, oldHash, newHash
+}
+
+func cachedRef(t *testing.T, flags *PullFlags, name plumbing.ReferenceName) plumbing.Hash {
+	t.Helper()
+	repo, err := git.PlainOpen(path.Join(flags.CacheDir, "actions", "checkout"))
+	require.NoError(t, err)
+	ref, err := repo.Reference(name, false)
+	require.NoError(t, err)
+	return ref.Hash()
+}
+
+func TestPullWithGitImpl_RefusesMovedTags(t *testing.T) {
+	flags, oldHash, newHash := setupMovedTag(t)
+
+	var out strings.Builder
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", &out, gitImplementation{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to update moved tags of actions/checkout (v1.0.0)")
+	assert.Contains(t, out.String(), "WARNING: tag v1.0.0 of actions/checkout moved upstream")
+
+	assert.Equal(t, oldHash, cachedRef(t, flags, "refs/tags/v1.0.0"), "the moved tag should be kept at its old commit")
+	assert.Equal(t, oldHash, cachedRef(t, flags, previousTagRefName("refs/tags/v1.0.0")), "the old target should be recorded")
+	assert.Equal(t, newHash, cachedRef(t, flags, "refs/tags/v1"), "floating tags are expected to move")
+	assert.Equal(t, newHash, cachedRef(t, flags, "refs/heads/main"))
+}
+
+func TestPullWithGitImpl_AllowTagMoves(t *testing.T) {
+	flags, oldHash, newHash := setupMovedTag(t)
+	flags.AllowTagMoves = true
+
+	var out strings.Builder
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", &out, gitImplementation{})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "--allow-tag-moves is set")
+
+	assert.Equal(t, newHash, cachedRef(t, flags, "refs/tags/v1.0.0"))
+	assert.Equal(t, oldHash, cachedRef(t, flags, previousTagRefName("refs/tags/v1.0.0")))
+}
+
+func TestPullWithGitImpl_FloatingTagsPattern(t *testing.T) {
+	flags, _, newHash := setupMovedTag(t)
+	flags.FloatingTags = `^v\d+(\.\d+)*$`
+
+	err := PullWithGitImpl(context.Background(), flags, nil, "actions/checkout", io.Discard, gitImplementation{})
+	require.NoError(t, err)
+	assert.Equal(t, newHash, cachedRef(t, flags, "refs/tags/v1.0.0"))
+}
+
+func TestPullOnlyFlags_Validate_FloatingTags(t *testing.T) {
+	f := &PullOnlyFlags{SourceURL: "https://github.com", FloatingTags: "v(4"}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--floating-tags")
+}
@@ -8,11 +8,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/google/go-github/v43/github"
@@ -53,10 +55,15 @@ func (m *mockReferenceIter) ForEach(fn func(*plumbing.Reference) error) error {
 
 func (m *mockReferenceIter) Close() {}
 
-// mockGitRepository is a GitRepository test double backed by a fixed set of refs.
+// mockGitRepository is a GitRepository test double backed by a fixed set of
+// refs. tagTargets maps annotated tag object hashes to the commit they tag.
+// removed records the refs deleted through RemoveReference.
 type mockGitRepository struct {
-	refs []*plumbing.Reference
-	err  error
+	refs       []*plumbing.Reference
+	err        error
+	tagTargets map[plumbing.Hash]plumbing.Hash
+	removed    []plumbing.ReferenceName
+	config     *config.Config
 }
 
 func (m *mockGitRepository) DeleteRemote(name string) error {
@@ -82,23 +89,68 @@ func (m *mockGitRepository) Head() (*plumbing.Reference, error) {
 	return plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.ZeroHash), nil
 }
 
+func (m *mockGitRepository) Remote(name string) (GitRemote, error) {
+	return &mockGitRemote{remoteConfig: &config.RemoteConfig{Name: name}}, nil
+}
+
+func (m *mockGitRepository) TagObject(h plumbing.Hash) (*object.Tag, error) {
+	target, ok := m.tagTargets[h]
+	if !ok {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	return &object.Tag{Hash: h, Target: target, TargetType: plumbing.CommitObject}, nil
+}
+
+func (m *mockGitRepository) CommitObject(h plumbing.Hash) (*object.Commit, error) {
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (m *mockGitRepository) SetReference(ref *plumbing.Reference) error {
+	return nil
+}
+
+func (m *mockGitRepository) RemoveReference(name plumbing.ReferenceName) error {
+	m.removed = append(m.removed, name)
+	return nil
+}
+
+func (m *mockGitRepository) Config() (*config.Config, error) {
+	if m.config == nil {
+		return config.NewConfig(), nil
+	}
+	return m.config, nil
+}
+
+func (m *mockGitRepository) SetConfig(cfg *config.Config) error {
+	m.config = cfg
+	return nil
+}
+
 // mockGitRemote is a GitRemote test double that records the refspecs it was
-// asked to push.
+// asked to push and advertises listRefs when listed.
 type mockGitRemote struct {
 	pushCalls       [][]config.RefSpec
+	pushOptions     *git.PushOptions
 	pushError       error
 	alreadyUpToDate bool
 	remoteConfig    *config.RemoteConfig
+	listRefs        []*plumbing.Reference
+	listErr         error
 }
 
 func (m *mockGitRemote) PushContext(ctx context.Context, o *git.PushOptions) error {
 	m.pushCalls = append(m.pushCalls, o.RefSpecs)
+	m.pushOptions = o
 	if m.alreadyUpToDate {
 		return git.NoErrAlreadyUpToDate
 	}
 	return m.pushError
 }
 
+func (m *mockGitRemote) ListContext(ctx context.Context, o *git.ListOptions) ([]*plumbing.Reference, error) {
+	return m.listRefs, m.listErr
+}
+
 func (m *mockGitRemote) Config() *config.RemoteConfig {
 	if m.remoteConfig != nil {
 		return m.remoteConfig
@@ -106,11 +158,27 @@ func (m *mockGitRemote) Config() *config.RemoteConfig {
 	return &config.RemoteConfig{Name: "test-remote"}
 }
 
+// newTestPullFlags returns pull flags for the given cache directory pulling
+// from github.com one repository at a time.
+func newTestPullFlags(cacheDir string, defaultBranchOnly bool) *PullFlags {
+	return &PullFlags{
+		CommonFlags: CommonFlags{CacheDir: cacheDir},
+		PullOnlyFlags: PullOnlyFlags{
+			SourceURL:         "https://github.com",
+			DefaultBranchOnly: defaultBranchOnly,
+			Concurrency:       DefaultConcurrency,
+			CacheLayout:       CacheLayoutBare,
+		},
+	}
+}
+
 // fakePullGitImpl is a GitImplementation test double that records the auth
 // handed to clone/fetch so tests can assert the source token is threaded all
 // the way down to git operations. cloneErr, when set, makes CloneRepository
-// fail so error paths can be exercised.
+// fail so error paths can be exercised. converted is what ConvertToBare
+// reports. It is safe for concurrent use.
 type fakePullGitImpl struct {
+	mu                sync.Mutex
 	exists            bool
 	repo              *fakePullRepo
 	cloneAuth         transport.AuthMethod
@@ -118,16 +186,24 @@ type fakePullGitImpl struct {
 	cloneErr          error
 	cloneSingleBranch bool
 	cloneRefName      plumbing.ReferenceName
+	cloneTags         git.TagMode
+	cloneBare         bool
+	convertCount      int
+	converted         bool
 }
 
 func (f *fakePullGitImpl) NewGitRepository(dir string) (GitRepository, error) {
 	return f.repo, nil
 }
 
-func (f *fakePullGitImpl) CloneRepository(dir string, o *git.CloneOptions) (GitRepository, error) {
+func (f *fakePullGitImpl) CloneRepository(dir string, bare bool, o *git.CloneOptions) (GitRepository, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cloneBare = bare
 	f.cloneAuth = o.Auth
 	f.cloneSingleBranch = o.SingleBranch
 	f.cloneRefName = o.ReferenceName
+	f.cloneTags = o.Tags
 	f.cloneCount++
 	if f.cloneErr != nil {
 		return nil, f.cloneErr
@@ -139,13 +215,22 @@ func (f *fakePullGitImpl) RepositoryExists(dir string) bool {
 	return f.exists
 }
 
+func (f *fakePullGitImpl) ConvertToBare(dir string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.convertCount++
+	return f.converted, nil
+}
+
 // fakePullRepo is a GitRepository test double that records the auth used on
 // FetchContext. fetchErr, when set, makes FetchContext fail so error paths can
 // be exercised. headBranch sets the branch HEAD resolves to (defaulting to
 // "main"); headErr, when set, makes Head fail. branches lists every branch
 // present locally so tests can assert that default-branch-only fetches only the
-// HEAD branch even when several branches are cached.
+// HEAD branch even when several branches are cached. remoteRefs are the refs
+// advertised by the origin remote. The config set with SetConfig is kept.
 type fakePullRepo struct {
+	mu            sync.Mutex
 	fetchAuth     transport.AuthMethod
 	fetchCalled   bool
 	fetchErr      error
@@ -154,6 +239,9 @@ type fakePullRepo struct {
 	branches      []string
 	headBranch    string
 	headErr       error
+	remoteRefs    []*plumbing.Reference
+	remoteCalls   int
+	config        *config.Config
 }
 
 func (r *fakePullRepo) DeleteRemote(string) error                            { return nil }
@@ -178,7 +266,48 @@ func (r *fakePullRepo) Head() (*plumbing.Reference, error) {
 	return plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), plumbing.ZeroHash), nil
 }
 
+func (r *fakePullRepo) TagObject(h plumbing.Hash) (*object.Tag, error) {
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (r *fakePullRepo) CommitObject(h plumbing.Hash) (*object.Commit, error) {
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (r *fakePullRepo) SetReference(ref *plumbing.Reference) error {
+	return nil
+}
+
+func (r *fakePullRepo) RemoveReference(name plumbing.ReferenceName) error {
+	return nil
+}
+
+func (r *fakePullRepo) Config() (*config.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config != nil {
+		return r.config, nil
+	}
+	return config.NewConfig(), nil
+}
+
+func (r *fakePullRepo) SetConfig(cfg *config.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = cfg
+	return nil
+}
+
+func (r *fakePullRepo) Remote(name string) (GitRemote, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remoteCalls++
+	return &mockGitRemote{remoteConfig: &config.RemoteConfig{Name: name}, listRefs: r.remoteRefs}, nil
+}
+
 func (r *fakePullRepo) FetchContext(ctx context.Context, o *git.FetchOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.fetchCalled = true
 	r.fetchAuth = o.Auth
 	r.fetchRefSpecs = o.RefSpecs
@@ -265,7 +394,7 @@ func (f *fakeGitHub) handler(t *testing.T) http.HandlerFunc {
 			}
 			if status == http.StatusOK {
 				cloneURL := "https://example.com/" + owner + "/" + repo + ".git"
-				b, _ := json.Marshal(github.Repository{Name: github.String(repo), CloneURL: &cloneURL})
+				b, _ := json.Marshal(github.Repository{Name: github.String(repo), FullName: github.String(owner + "/" + repo), CloneURL: &cloneURL})
 				_, _ = w.Write(b)
 				return
 			}
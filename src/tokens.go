@@ -0,0 +1,208 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// Environment variables read when no token is given with a flag, so tokens
+// don't have to appear in process listings or shell history.
+const (
+	SourceTokenEnv      = "ACTIONS_SYNC_SOURCE_TOKEN"
+	DestinationTokenEnv = "ACTIONS_SYNC_DESTINATION_TOKEN"
+)
+
+// tokenOptions are the ways a token can be supplied. prefix is the flag prefix,
+// either `source` or `destination`.
+type tokenOptions struct {
+	prefix, token, file, command, env string
+}
+
+// count returns how many of the token flags are set; the environment variable
+// is only a fallback and isn't counted.
+func (o tokenOptions) count() int {
+	n := 0
+	for _, v := range []string{o.token, o.file, o.command} {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// IsSet reports whether a token is supplied in any way.
+func (o tokenOptions) IsSet() bool {
+	return o.count() > 0 || os.Getenv(o.env) != ""
+}
+
+func (o tokenOptions) Validate() Validations {
+	var validations Validations
+	if o.count() > 1 {
+		validations = append(validations, fmt.Sprintf("only one of --%[1]s-token, --%[1]s-token-file and --%[1]s-token-command can be set", o.prefix))
+	}
+	return validations
+}
+
+// TokenSource returns the token supplied by the flags or the environment. A
+// token command is run straight away, and again whenever the returned source
+// is refreshed. It returns nil when no token is supplied.
+func (o tokenOptions) TokenSource() (oauth2.TokenSource, error) {
+	switch {
+	case o.token != "":
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.token}), nil
+	case o.file != "":
+		b, err := os.ReadFile(o.file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading --%s-token-file", o.prefix)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return nil, errors.Errorf("--%s-token-file `%s` is empty", o.prefix, o.file)
+		}
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+	case o.command != "":
+		ts := &commandTokenSource{command: o.command}
+		if err := ts.Refresh(0); err != nil {
+			return nil, errors.Wrapf(err, "error running --%s-token-command", o.prefix)
+		}
+		return ts, nil
+	case os.Getenv(o.env) != "":
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: os.Getenv(o.env)}), nil
+	}
+	return nil, nil
+}
+
+// tokenRefresher is implemented by token sources that can replace their token
+// on demand, for example after the server rejected it.
+type tokenRefresher interface {
+	// Generation counts the times the token was replaced. Callers read it
+	// before using a token and pass it to Refresh when the token is rejected.
+	Generation() uint64
+	// Refresh replaces the token unless it was already replaced since
+	// generation, so requests that are all rejected at once get a single new
+	// token.
+	Refresh(generation uint64) error
+}
+
+// commandTokenSource hands out the token printed by an external credential
+// helper. The helper is only run again when Refresh is called.
+type commandTokenSource struct {
+	command string
+
+	mu         sync.Mutex
+	token      string
+	generation uint64
+	// refreshMu runs the command for one refresh at a time, as concurrent
+	// pushes and pulls can all be rejected at once
+	refreshMu sync.Mutex
+}
+
+func (s *commandTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &oauth2.Token{AccessToken: s.token}, nil
+}
+
+func (s *commandTokenSource) Generation() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+func (s *commandTokenSource) Refresh(generation uint64) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if s.Generation() != generation {
+		// another rejected request already got a new token
+		return nil
+	}
+	token, err := runTokenCommand(s.command)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	s.generation++
+	return nil
+}
+
+// runTokenCommand runs command with the system shell and returns what it
+// printed to stdout, without surrounding whitespace. The command's stderr is
+// passed through so helpers can prompt or explain failures.
+func runTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", errors.New("the command did not print a token")
+	}
+	return token, nil
+}
+
+// refreshingTransport retries a request once with a new token when the server
+// answers 401 Unauthorized and the token source can be refreshed.
+type refreshingTransport struct {
+	source oauth2.TokenSource
+	base   http.RoundTripper
+}
+
+// newTokenHTTPClient returns an HTTP client that authenticates with tokens
+// from ts and, where ts supports it, refreshes them when they are rejected.
+// Requests are sent with base.
+func newTokenHTTPClient(ts oauth2.TokenSource, base http.RoundTripper) *http.Client {
+	// oauth2.Transport asks ts for a token on every request, unlike
+	// oauth2.NewClient which would keep using a rejected token.
+	var tokenTransport http.RoundTripper = &oauth2.Transport{Source: ts, Base: base}
+	if rts, ok := ts.(requestTokenSource); ok {
+		tokenTransport = &requestTokenTransport{source: rts, base: base}
+	}
+	return &http.Client{Transport: &refreshingTransport{
+		source: ts,
+		base:   tokenTransport,
+	}}
+}
+
+func (t *refreshingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	refresher, ok := t.source.(tokenRefresher)
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return t.base.RoundTrip(req)
+	}
+
+	generation := refresher.Generation()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if err := refresher.Refresh(generation); err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
@@ -0,0 +1,157 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenOptions_TokenSource(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := path.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0o600))
+	t.Setenv(DestinationTokenEnv, "from-env")
+
+	tests := []struct {
+		name string
+		opts tokenOptions
+		want string
+	}{
+		{"flag wins over the environment", tokenOptions{token: "from-flag", env: DestinationTokenEnv}, "from-flag"},
+		{"file", tokenOptions{file: tokenFile, env: DestinationTokenEnv}, "from-file"},
+		{"command", tokenOptions{command: "echo from-command", env: DestinationTokenEnv}, "from-command"},
+		{"environment", tokenOptions{env: DestinationTokenEnv}, "from-env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := tt.opts.TokenSource()
+			require.NoError(t, err)
+			token, err := ts.Token()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, token.AccessToken)
+		})
+	}
+
+	ts, err := tokenOptions{env: SourceTokenEnv}.TokenSource()
+	require.NoError(t, err)
+	assert.Nil(t, ts, "no token is supplied")
+}
+
+func TestTokenOptions_Errors(t *testing.T) {
+	emptyFile := path.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(emptyFile, []byte("\n"), 0o600))
+
+	_, err := tokenOptions{prefix: "source", file: emptyFile}.TokenSource()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is empty")
+
+	_, err = tokenOptions{prefix: "source", command: "exit 3"}.TokenSource()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--source-token-command")
+
+	_, err = tokenOptions{prefix: "source", command: "true"}.TokenSource()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not print a token")
+
+	validations := tokenOptions{prefix: "destination", token: "a", file: "b"}.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "--destination-token-file")
+}
+
+func TestPushOnlyFlags_Validate_TokenEnv(t *testing.T) {
+	f := PushOnlyFlags{BaseURL: "https://ghes.example.com"}
+	require.Len(t, f.Validate(), 1)
+
+	t.Setenv(DestinationTokenEnv, "token")
+	assert.Empty(t, f.Validate())
+}
+
+func TestPullOnlyFlags_Validate_TokenCommandRequiresHTTPS(t *testing.T) {
+	f := PullOnlyFlags{SourceURL: "http://github.example.com", TokenCommand: "echo token"}
+	validations := f.Validate()
+	require.Len(t, validations, 1)
+	assert.Contains(t, validations[0], "https://")
+}
+
+func TestNewTokenHTTPClient_RerunsCommandOn401(t *testing.T) {
+	// the command prints a new token every time it runs
+	counter := path.Join(t.TempDir(), "counter")
+	command := fmt.Sprintf(`echo x >> %[1]s; echo token-$(wc -l < %[1]s | tr -d ' ')`, counter)
+	ts, err := tokenOptions{prefix: "destination", command: command}.TokenSource()
+	require.NoError(t, err)
+
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	resp, err := newTokenHTTPClient(ts, nil).Post(server.URL, "text/plain", strings.NewReader("body"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, seen)
+}
+
+func TestNewTokenHTTPClient_RerunsCommandOnceForConcurrent401s(t *testing.T) {
+	counter := path.Join(t.TempDir(), "counter")
+	command := fmt.Sprintf(`echo x >> %[1]s; echo token-$(wc -l < %[1]s | tr -d ' ')`, counter)
+	ts, err := tokenOptions{prefix: "destination", command: command}.TokenSource()
+	require.NoError(t, err)
+
+	// every request is answered only once all of them were sent with the
+	// first token, so they are all rejected at the same time
+	const requests = 8
+	var rejected sync.WaitGroup
+	rejected.Add(requests)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			rejected.Done()
+			rejected.Wait()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	client := newTokenHTTPClient(ts, nil)
+	var wg sync.WaitGroup
+	bodies := make([]string, requests)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			bodies[i] = string(body)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, body := range bodies {
+		assert.Equal(t, "Bearer token-2", body)
+	}
+	runs, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(runs), "x"), "the command runs once up front and once for all the rejections")
+}
@@ -0,0 +1,145 @@
+package src
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// commitSHARegExp matches refs that are a full commit SHA rather than a branch
+// or tag name.
+var commitSHARegExp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// actionRef is a `uses:` reference to an action or reusable workflow in another
+// repository, such as `actions/checkout@v4` or
+// `octo-org/workflows/.github/workflows/ci.yml@main`.
+type actionRef struct {
+	owner, repo string
+	// path is the directory of the action or the workflow file within the
+	// repository, empty for an action at the root.
+	path string
+	ref  string
+}
+
+// nwo returns the `owner/repo` name of the referenced repository.
+func (r actionRef) nwo() string {
+	return fmt.Sprintf("%s/%s", r.owner, r.repo)
+}
+
+// String returns the repository and ref in the form used by repo lists.
+func (r actionRef) String() string {
+	return fmt.Sprintf("%s@%s", r.nwo(), r.ref)
+}
+
+// isCommitSHA reports whether the ref pins a commit rather than naming a
+// branch or tag.
+func (r actionRef) isCommitSHA() bool {
+	return commitSHARegExp.MatchString(strings.ToLower(r.ref))
+}
+
+// parseActionRef parses the value of a `uses:` key. Local (`./`) and Docker
+// (`docker://`) references and expressions don't name a repository, so they
+// are reported as not ok.
+func parseActionRef(uses string) (actionRef, bool) {
+	uses = strings.TrimSpace(uses)
+	if uses == "" || strings.HasPrefix(uses, ".") || strings.HasPrefix(uses, "docker://") || strings.Contains(uses, "${{") {
+		return actionRef{}, false
+	}
+	name, ref, found := strings.Cut(uses, "@")
+	if !found || ref == "" {
+		return actionRef{}, false
+	}
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return actionRef{}, false
+	}
+	r := actionRef{owner: parts[0], repo: parts[1], ref: ref}
+	if len(parts) == 3 {
+		r.path = strings.Trim(parts[2], "/")
+	}
+	return r, true
+}
+
+// findUses returns the repository references in the `uses:` keys of an
+// action.yml or workflow file, in the order they appear.
+func findUses(content []byte) ([]actionRef, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, errors.Wrap(err, "error parsing YAML")
+	}
+	var refs []actionRef
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if key.Value == "uses" && value.Kind == yaml.ScalarNode {
+					if ref, ok := parseActionRef(value.Value); ok {
+						refs = append(refs, ref)
+					}
+					continue
+				}
+				walk(value)
+			}
+			return
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(&doc)
+	return refs, nil
+}
+
+// isReusableWorkflow reports whether a workflow can be called from other
+// workflows, that is whether it is triggered by `workflow_call`.
+func isReusableWorkflow(content []byte) bool {
+	var workflow struct {
+		On yaml.Node `yaml:"on"`
+	}
+	if err := yaml.Unmarshal(content, &workflow); err != nil {
+		return false
+	}
+	on := workflow.On
+	switch on.Kind {
+	case yaml.ScalarNode:
+		return on.Value == "workflow_call"
+	case yaml.SequenceNode:
+		for _, event := range on.Content {
+			if event.Value == "workflow_call" {
+				return true
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(on.Content); i += 2 {
+			if on.Content[i].Value == "workflow_call" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWorkflowFile reports whether the file at the slash separated path within a
+// repository is a workflow.
+func isWorkflowFile(filePath string) bool {
+	dir, base := path.Split(filePath)
+	return (dir == ".github/workflows/" || strings.HasSuffix(dir, "/.github/workflows/")) && (strings.HasSuffix(base, ".yml") || strings.HasSuffix(base, ".yaml"))
+}
+
+// isActionFile reports whether the file at the slash separated path within a
+// repository is an action metadata file or a workflow.
+func isActionFile(filePath string) bool {
+	base := path.Base(filePath)
+	return base == "action.yml" || base == "action.yaml" || isWorkflowFile(filePath)
+}
+
+// skipActionDir reports whether a directory cannot hold actions or workflows
+// worth scanning, such as vendored dependencies.
+func skipActionDir(name string) bool {
+	return name == "node_modules" || name == ".git" || (strings.HasPrefix(name, ".") && name != ".github")
+}
@@ -0,0 +1,81 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActionRef(t *testing.T) {
+	ref, ok := parseActionRef("actions/checkout@v4")
+	require.True(t, ok)
+	assert.Equal(t, actionRef{owner: "actions", repo: "checkout", ref: "v4"}, ref)
+	assert.Equal(t, "actions/checkout@v4", ref.String())
+
+	ref, ok = parseActionRef("octo-org/workflows/.github/workflows/ci.yml@main")
+	require.True(t, ok)
+	assert.Equal(t, "octo-org/workflows", ref.nwo())
+	assert.Equal(t, ".github/workflows/ci.yml", ref.path)
+
+	ref, ok = parseActionRef("github/codeql-action/init@8a470fddafa5cbb6266ee11b37ef4d8aae19c571")
+	require.True(t, ok)
+	assert.True(t, ref.isCommitSHA())
+
+	for _, uses := range []string{"./", "./.github/actions/build", "../other", "docker://alpine:3.19", "actions/checkout", "checkout@v4", "${{ matrix.action }}@v1", ""} {
+		_, ok := parseActionRef(uses)
+		assert.False(t, ok, uses)
+	}
+}
+
+func TestFindUses(t *testing.T) {
+	action := `
+name: composite
+runs:
+  using: composite
+  steps:
+    - uses: actions/setup-node@v4
+    - uses: ./local
+    - uses: docker://alpine
+    - run: 'echo uses: not/this@v1'
+      shell: bash
+`
+	refs, err := findUses([]byte(action))
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	assert.Equal(t, "actions/setup-node@v4", refs[0].String())
+
+	workflow := `
+on:
+  workflow_call:
+jobs:
+  test:
+    uses: octo-org/workflows/.github/workflows/test.yml@v2
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: 'actions/checkout@v4'
+`
+	refs, err = findUses([]byte(workflow))
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+	assert.Equal(t, "octo-org/workflows@v2", refs[0].String())
+	assert.Equal(t, "actions/checkout@v4", refs[1].String())
+	assert.True(t, isReusableWorkflow([]byte(workflow)))
+
+	assert.False(t, isReusableWorkflow([]byte("on: [push, pull_request]\n")))
+	assert.True(t, isReusableWorkflow([]byte("on: [push, workflow_call]\n")))
+	assert.True(t, isReusableWorkflow([]byte("on: workflow_call\n")))
+
+	_, err = findUses([]byte("steps: [uses"))
+	assert.Error(t, err)
+}
+
+func TestIsActionFile(t *testing.T) {
+	for _, p := range []string{"action.yml", "action.yaml", "init/action.yml", ".github/workflows/ci.yml", ".github/workflows/release.yaml"} {
+		assert.True(t, isActionFile(p), p)
+	}
+	for _, p := range []string{"README.md", ".github/workflows/sub/ci.yml", ".github/dependabot.yml", "workflows/ci.yml"} {
+		assert.False(t, isActionFile(p), p)
+	}
+}
@@ -0,0 +1,163 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+type repoStatus string
+
+const (
+	repoSucceeded repoStatus = "succeeded"
+	repoFailed    repoStatus = "failed"
+	repoSkipped   repoStatus = "skipped"
+)
+
+// repoResult records the outcome of processing a single repository.
+type repoResult struct {
+	repoName string
+	status   repoStatus
+	err      error
+}
+
+// forEachRepo calls fn for every repository name, running at most concurrency
+// calls at once. With a concurrency of 1 the repositories are processed in
+// order and fn writes straight to stdout. With more workers each call gets its
+// own line-prefixed writer so output from parallel repositories stays readable.
+//
+// By default the first error cancels the context handed to the remaining calls,
+// stops new repositories from being started and is returned once in-flight calls
+// finish. With continueOnError every repository is attempted, a summary table
+// of succeeded, failed and skipped repositories is printed at the end and an
+// error is returned if any repository failed.
+func forEachRepo(ctx context.Context, repoNames []string, concurrency int, continueOnError bool, fn func(ctx context.Context, repoName string, out io.Writer) error) error {
+	results, err := runEachRepo(ctx, repoNames, concurrency, continueOnError, fn)
+	if !continueOnError {
+		return err
+	}
+	return summarizeRepos(results, err)
+}
+
+// runEachRepo is forEachRepo without the summary, returning the outcome of
+// every repository instead, for callers that process repositories in several
+// rounds and summarize them once.
+func runEachRepo(ctx context.Context, repoNames []string, concurrency int, continueOnError bool, fn func(ctx context.Context, repoName string, out io.Writer) error) ([]repoResult, error) {
+	results := make([]repoResult, len(repoNames))
+	for i, repoName := range repoNames {
+		results[i] = repoResult{repoName: repoName, status: repoSkipped}
+	}
+
+	var err error
+	if concurrency <= 1 {
+		err = forEachRepoSequential(ctx, repoNames, continueOnError, fn, results)
+	} else {
+		err = forEachRepoConcurrent(ctx, repoNames, concurrency, continueOnError, fn, results)
+	}
+	return results, err
+}
+
+// summarizeRepos prints the summary table of results and returns an error if
+// any repository failed, or else err.
+func summarizeRepos(results []repoResult, err error) error {
+	failed := printRepoSummary(os.Stdout, results)
+	if failed > 0 {
+		return errors.Errorf("%d of %d repositories failed", failed, len(results))
+	}
+	return err
+}
+
+func forEachRepoSequential(ctx context.Context, repoNames []string, continueOnError bool, fn func(ctx context.Context, repoName string, out io.Writer) error, results []repoResult) error {
+	for i, repoName := range repoNames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(ctx, repoName, os.Stdout); err != nil {
+			results[i] = repoResult{repoName: repoName, status: repoFailed, err: err}
+			if !continueOnError {
+				return err
+			}
+			continue
+		}
+		results[i].status = repoSucceeded
+	}
+	return nil
+}
+
+func forEachRepoConcurrent(ctx context.Context, repoNames []string, concurrency int, continueOnError bool, fn func(ctx context.Context, repoName string, out io.Writer) error, results []repoResult) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	work := make(chan int)
+	for i := 0; i < concurrency && i < len(repoNames); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				out := newPrefixWriter(os.Stdout, repoNames[i])
+				err := fn(ctx, repoNames[i], out)
+				out.Flush()
+				if err == nil {
+					results[i].status = repoSucceeded
+					continue
+				}
+				results[i] = repoResult{repoName: repoNames[i], status: repoFailed, err: err}
+				if !continueOnError {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range repoNames {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
+
+// printRepoSummary writes a table with the outcome of every repository and
+// returns the number of repositories that failed.
+func printRepoSummary(w io.Writer, results []repoResult) int {
+	counts := map[repoStatus]int{}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSTATUS\tERROR")
+	for _, result := range results {
+		counts[result.status]++
+		errMsg := ""
+		if result.err != nil {
+			errMsg = result.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.repoName, result.status, errMsg)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "%d succeeded, %d failed, %d skipped\n", counts[repoSucceeded], counts[repoFailed], counts[repoSkipped])
+
+	return counts[repoFailed]
+}
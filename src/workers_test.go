@@ -0,0 +1,128 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachRepo_SequentialKeepsOrder(t *testing.T) {
+	var seen []string
+	err := forEachRepo(context.Background(), []string{"a/a", "b/b", "c/c"}, 1, false, func(ctx context.Context, repoName string, out io.Writer) error {
+		seen = append(seen, repoName)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/a", "b/b", "c/c"}, seen)
+}
+
+func TestForEachRepo_BoundsConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	var mu sync.Mutex
+	seen := map[string]bool{}
+
+	repoNames := []string{"o/a", "o/b", "o/c", "o/d", "o/e", "o/f", "o/g", "o/h"}
+	err := forEachRepo(context.Background(), repoNames, 3, false, func(ctx context.Context, repoName string, out io.Writer) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		seen[repoName] = true
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, seen, len(repoNames), "every repo should be processed")
+	assert.LessOrEqual(t, maxInFlight, int32(3), "no more than 3 repos should run at once")
+	assert.Greater(t, maxInFlight, int32(1), "repos should run in parallel")
+}
+
+func TestForEachRepo_ErrorCancelsRemainingWork(t *testing.T) {
+	var calls int32
+	repoNames := []string{"o/a", "o/b", "o/c", "o/d", "o/e", "o/f"}
+	err := forEachRepo(context.Background(), repoNames, 2, false, func(ctx context.Context, repoName string, out io.Writer) error {
+		atomic.AddInt32(&calls, 1)
+		if repoName == "o/a" {
+			return errors.New("boom")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.EqualError(t, err, "boom")
+	assert.Less(t, int(calls), len(repoNames), "remaining repos should not be started after a failure")
+}
+
+func TestForEachRepo_RespectsParentCancellation(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		called := false
+		err := forEachRepo(ctx, []string{"o/a", "o/b"}, concurrency, false, func(ctx context.Context, repoName string, out io.Writer) error {
+			called = true
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled, "concurrency=%d", concurrency)
+		if concurrency == 1 {
+			assert.False(t, called, "no repo should be started once the context is cancelled")
+		}
+	}
+}
+
+func TestForEachRepo_ContinueOnErrorAttemptsEveryRepo(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		var mu sync.Mutex
+		var seen []string
+		repoNames := []string{"o/a", "o/b", "o/c", "o/d"}
+		err := forEachRepo(context.Background(), repoNames, concurrency, true, func(ctx context.Context, repoName string, out io.Writer) error {
+			mu.Lock()
+			seen = append(seen, repoName)
+			mu.Unlock()
+			if repoName == "o/a" || repoName == "o/c" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		require.EqualError(t, err, "2 of 4 repositories failed", "concurrency=%d", concurrency)
+		assert.Len(t, seen, len(repoNames), "every repo should be attempted (concurrency=%d)", concurrency)
+	}
+}
+
+func TestForEachRepo_ContinueOnErrorAllSucceeded(t *testing.T) {
+	err := forEachRepo(context.Background(), []string{"o/a", "o/b"}, 1, true, func(ctx context.Context, repoName string, out io.Writer) error {
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPrintRepoSummary(t *testing.T) {
+	var buf bytes.Buffer
+	failed := printRepoSummary(&buf, []repoResult{
+		{repoName: "actions/checkout", status: repoSucceeded},
+		{repoName: "actions/deleted", status: repoFailed, err: errors.New("could not pull actions/deleted")},
+		{repoName: "actions/setup-go", status: repoSkipped},
+	})
+
+	assert.Equal(t, 1, failed)
+	out := buf.String()
+	assert.Contains(t, out, "REPOSITORY")
+	assert.Regexp(t, `actions/checkout\s+succeeded`, out)
+	assert.Regexp(t, `actions/deleted\s+failed\s+could not pull actions/deleted`, out)
+	assert.Regexp(t, `actions/setup-go\s+skipped`, out)
+	assert.Contains(t, out, "1 succeeded, 1 failed, 1 skipped")
+}